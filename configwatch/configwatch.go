@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package configwatch lets a long-running process subscribe to
+// configuration changes pushed through an external KV backend (etcd,
+// Consul, a watched local file, ...), so named entries can be added,
+// removed, or modified at runtime instead of requiring a restart.
+package configwatch
+
+// EventType classifies a configuration change reported by a Source.
+type EventType int
+
+const(
+	// EventPut indicates a key was created or updated; Event.Value
+	// holds the new value.
+	EventPut EventType = iota
+
+	// EventDelete indicates a key was removed. Event.Value is nil.
+	EventDelete
+)
+
+// Event is a single configuration change under a Source's watched
+// prefix.
+type Event struct {
+	// Type is the kind of change.
+	Type EventType
+
+	// Key is the full key the change applies to.
+	Key string
+
+	// Value is the key's new value for an EventPut. It is nil for an
+	// EventDelete.
+	Value []byte
+}
+
+// Source streams configuration changes from a KV backend. It must be
+// Closed once it is no longer needed.
+type Source interface {
+	// Events returns the channel of configuration changes. It is
+	// closed once the Source is Closed, or if the underlying backend
+	// connection is irrecoverably lost.
+	Events() <-chan Event
+
+	// Close releases any resources held by the Source.
+	Close() error
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package configwatch
+
+import(
+	"context"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"time"
+)
+
+// etcdSource is a Source backed by an etcd watch over a key prefix.
+type etcdSource struct {
+	client *clientv3.Client
+	cancel context.CancelFunc
+	events chan Event
+}
+
+// NewEtcdSource dials the etcd cluster at endpoints and watches every
+// key under prefix for changes, reporting them on the returned
+// Source's Events channel. The dial is aborted after dialTimeout.
+func NewEtcdSource( endpoints []string, prefix string, dialTimeout time.Duration ) ( Source, error ) {
+	client, err := clientv3.New( clientv3.Config{
+		Endpoints: endpoints,
+		DialTimeout: dialTimeout,
+	} )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to connect to etcd: %v", err )
+	}
+	ctx, cancel := context.WithCancel( context.Background() )
+	source := &etcdSource{
+		client: client,
+		cancel: cancel,
+		events: make( chan Event ),
+	}
+	go source.watch( ctx, prefix )
+
+	return source, nil
+}
+
+// watch relays etcd watch responses for prefix to s.events until ctx is
+// cancelled or the watch channel closes, then closes s.events.
+func ( s *etcdSource ) watch( ctx context.Context, prefix string ) {
+	defer close( s.events )
+	watchChan := s.client.Watch( ctx, prefix, clientv3.WithPrefix() )
+	for resp := range watchChan {
+		for _, ev := range resp.Events {
+			switch ev.Type {
+			case clientv3.EventTypePut:
+				s.events <- Event{ Type: EventPut, Key: string( ev.Kv.Key ), Value: ev.Kv.Value }
+			case clientv3.EventTypeDelete:
+				s.events <- Event{ Type: EventDelete, Key: string( ev.Kv.Key ) }
+			}
+		}
+	}
+}
+
+// Events implements Source.
+func ( s *etcdSource ) Events() <-chan Event {
+	return s.events
+}
+
+// Close implements Source.
+func ( s *etcdSource ) Close() error {
+	s.cancel()
+
+	return s.client.Close()
+}
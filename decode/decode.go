@@ -0,0 +1,401 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package decode turns the raw register bytes a modbus command returns
+// into a typed map, so a consumer does not have to hand-roll the
+// byte/word-order shuffling multi-register values need across vendors.
+package decode
+
+import(
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Type names a value's wire encoding, as declared in a Field's Type.
+type Type string
+
+const(
+	// TypeU16 is an unsigned 16 bit integer occupying one register.
+	TypeU16 Type = "u16"
+
+	// TypeI16 is a two's complement signed 16 bit integer occupying one
+	// register.
+	TypeI16 Type = "i16"
+
+	// TypeU32BE is an unsigned 32 bit integer spanning two registers in
+	// big-endian word and byte order (ABCD).
+	TypeU32BE Type = "u32_be"
+
+	// TypeU32LE is an unsigned 32 bit integer spanning two registers in
+	// little-endian word and byte order (DCBA).
+	TypeU32LE Type = "u32_le"
+
+	// TypeU32BEBS is an unsigned 32 bit integer spanning two registers
+	// in big-endian word order with the bytes swapped within each
+	// register (BADC).
+	TypeU32BEBS Type = "u32_bebs"
+
+	// TypeU32LEBS is an unsigned 32 bit integer spanning two registers
+	// in little-endian word order with the bytes kept in big-endian
+	// order within each register (CDAB).
+	TypeU32LEBS Type = "u32_lebs"
+
+	// TypeI32BE is the two's complement signed counterpart of TypeU32BE.
+	TypeI32BE Type = "i32_be"
+
+	// TypeI32LE is the two's complement signed counterpart of TypeU32LE.
+	TypeI32LE Type = "i32_le"
+
+	// TypeI32BEBS is the two's complement signed counterpart of
+	// TypeU32BEBS.
+	TypeI32BEBS Type = "i32_bebs"
+
+	// TypeI32LEBS is the two's complement signed counterpart of
+	// TypeU32LEBS.
+	TypeI32LEBS Type = "i32_lebs"
+
+	// TypeF32BE is an IEEE 754 single precision float, word/byte order
+	// as TypeU32BE.
+	TypeF32BE Type = "f32_be"
+
+	// TypeF32LE is an IEEE 754 single precision float, word/byte order
+	// as TypeU32LE.
+	TypeF32LE Type = "f32_le"
+
+	// TypeF32BEBS is an IEEE 754 single precision float, word/byte order
+	// as TypeU32BEBS.
+	TypeF32BEBS Type = "f32_bebs"
+
+	// TypeF32LEBS is an IEEE 754 single precision float, word/byte order
+	// as TypeU32LEBS.
+	TypeF32LEBS Type = "f32_lebs"
+
+	// TypeU64BE is an unsigned 64 bit integer spanning four registers,
+	// word/byte order as TypeU32BE.
+	TypeU64BE Type = "u64_be"
+
+	// TypeU64LE is an unsigned 64 bit integer spanning four registers,
+	// word/byte order as TypeU32LE.
+	TypeU64LE Type = "u64_le"
+
+	// TypeU64BEBS is an unsigned 64 bit integer spanning four registers,
+	// word/byte order as TypeU32BEBS.
+	TypeU64BEBS Type = "u64_bebs"
+
+	// TypeU64LEBS is an unsigned 64 bit integer spanning four registers,
+	// word/byte order as TypeU32LEBS.
+	TypeU64LEBS Type = "u64_lebs"
+
+	// TypeI64BE is the two's complement signed counterpart of TypeU64BE.
+	TypeI64BE Type = "i64_be"
+
+	// TypeI64LE is the two's complement signed counterpart of TypeU64LE.
+	TypeI64LE Type = "i64_le"
+
+	// TypeI64BEBS is the two's complement signed counterpart of
+	// TypeU64BEBS.
+	TypeI64BEBS Type = "i64_bebs"
+
+	// TypeI64LEBS is the two's complement signed counterpart of
+	// TypeU64LEBS.
+	TypeI64LEBS Type = "i64_lebs"
+
+	// TypeF64BE is an IEEE 754 double precision float, word/byte order
+	// as TypeU64BE.
+	TypeF64BE Type = "f64_be"
+
+	// TypeF64LE is an IEEE 754 double precision float, word/byte order
+	// as TypeU64LE.
+	TypeF64LE Type = "f64_le"
+
+	// TypeF64BEBS is an IEEE 754 double precision float, word/byte order
+	// as TypeU64BEBS.
+	TypeF64BEBS Type = "f64_bebs"
+
+	// TypeF64LEBS is an IEEE 754 double precision float, word/byte order
+	// as TypeU64LEBS.
+	TypeF64LEBS Type = "f64_lebs"
+
+	// TypeBitfield decodes Field.Length individual bits, named by
+	// Field.Bits, least significant bit first, in the style of
+	// httpd's builder.go bitmap configuration.
+	TypeBitfield Type = "bitfield"
+
+	// TypeFixed is a two's complement signed 16 bit integer interpreted
+	// as value*Field.Scale + Field.FixedOffset.
+	TypeFixed Type = "fixed"
+
+	// TypeASCII is a Field.Length byte ASCII string.
+	TypeASCII Type = "ascii"
+)
+
+// numKind distinguishes the numeric interpretation of a multi-register
+// Type.
+type numKind int
+
+const(
+	kindUnsigned numKind = iota
+	kindSigned
+	kindFloat
+)
+
+// numericInfo describes how to decode one of the multi-register numeric
+// types: its width in bytes, its numeric interpretation, and the
+// register/byte order reorderWords must apply to recover a canonical
+// big-endian value.
+type numericInfo struct {
+	width uint
+	kind numKind
+	wordsReversed bool
+	bytesSwapped bool
+}
+
+// numericTypes maps every multi-register numeric Type to its decoding
+// parameters. be leaves the registers as they arrive; le reverses both
+// the register order and the bytes within each register; bebs swaps only
+// the bytes within each register; lebs reverses only the register order.
+var numericTypes = map[Type]numericInfo{
+	TypeU32BE: { 4, kindUnsigned, false, false },
+	TypeU32LE: { 4, kindUnsigned, true, true },
+	TypeU32BEBS: { 4, kindUnsigned, false, true },
+	TypeU32LEBS: { 4, kindUnsigned, true, false },
+	TypeI32BE: { 4, kindSigned, false, false },
+	TypeI32LE: { 4, kindSigned, true, true },
+	TypeI32BEBS: { 4, kindSigned, false, true },
+	TypeI32LEBS: { 4, kindSigned, true, false },
+	TypeF32BE: { 4, kindFloat, false, false },
+	TypeF32LE: { 4, kindFloat, true, true },
+	TypeF32BEBS: { 4, kindFloat, false, true },
+	TypeF32LEBS: { 4, kindFloat, true, false },
+	TypeU64BE: { 8, kindUnsigned, false, false },
+	TypeU64LE: { 8, kindUnsigned, true, true },
+	TypeU64BEBS: { 8, kindUnsigned, false, true },
+	TypeU64LEBS: { 8, kindUnsigned, true, false },
+	TypeI64BE: { 8, kindSigned, false, false },
+	TypeI64LE: { 8, kindSigned, true, true },
+	TypeI64BEBS: { 8, kindSigned, false, true },
+	TypeI64LEBS: { 8, kindSigned, true, false },
+	TypeF64BE: { 8, kindFloat, false, false },
+	TypeF64LE: { 8, kindFloat, true, true },
+	TypeF64BEBS: { 8, kindFloat, false, true },
+	TypeF64LEBS: { 8, kindFloat, true, false },
+}
+
+// Field declares one named value within a command's register map.
+type Field struct {
+	// Name is the key the decoded value is stored under.
+	Name string
+
+	// Offset is the zero-based register (16 bit word) offset of the
+	// value within the command's data.
+	Offset uint
+
+	// Type selects how the bytes at Offset are interpreted.
+	Type Type
+
+	// Length is the number of bits for TypeBitfield, or the number of
+	// bytes for TypeASCII. It is ignored for every other Type.
+	Length uint
+
+	// Bits names the individual bits of a TypeBitfield value, least
+	// significant bit first. An empty name leaves the corresponding bit
+	// out of the decoded result. Ignored for every other Type.
+	Bits []string
+
+	// Scale and FixedOffset apply to a TypeFixed value as
+	// value*Scale + FixedOffset. Scale defaults to 1 if zero. Ignored
+	// for every other Type.
+	Scale float64
+	FixedOffset float64
+}
+
+// sizeOf returns the number of raw bytes f.Type occupies, validating
+// f.Length where it applies.
+func sizeOf( f Field ) ( uint, error ) {
+	switch f.Type {
+	case TypeU16, TypeI16, TypeFixed:
+		return 2, nil
+	case TypeBitfield:
+		if f.Length == 0 {
+			return 0, errors.New( "bitfield type requires a non-zero Length" )
+		}
+
+		return ( f.Length + 7 ) / 8, nil
+	case TypeASCII:
+		if f.Length == 0 {
+			return 0, errors.New( "ascii type requires a non-zero Length" )
+		}
+
+		return f.Length, nil
+	}
+	if info, ok := numericTypes[f.Type]; ok {
+		return info.width, nil
+	}
+
+	return 0, fmt.Errorf( "unknown type '%s'", f.Type )
+}
+
+// ReorderWords returns a copy of raw, a multi-register value's wire
+// bytes, with its register order reversed if wordsReversed and the two
+// bytes within each register swapped if bytesSwapped. Applying it
+// twice with the same arguments restores the original bytes, so the
+// same call converts wire order to canonical big-endian order and back
+// again. Exported so httpd's builder package, which applies the same
+// per-value word-order reordering against its own kWordOrder config
+// key, does not have to keep its own copy in sync by hand.
+func ReorderWords( raw []byte, wordsReversed, bytesSwapped bool ) []byte {
+	n := len( raw ) / 2
+	out := make( []byte, len( raw ) )
+	for i := 0; i != n; i++ {
+		src := i
+		if wordsReversed {
+			src = n - 1 - i
+		}
+		hi, lo := raw[2 * src], raw[2 * src + 1]
+		if bytesSwapped {
+			hi, lo = lo, hi
+		}
+		out[2 * i], out[2 * i + 1] = hi, lo
+	}
+
+	return out
+}
+
+// decodeBitfield unpacks the bits named by bits out of raw, least
+// significant bit first.
+func decodeBitfield( raw []byte, bits []string ) map[string]bool {
+	result := make( map[string]bool, len( bits ) )
+	for i, name := range bits {
+		if name == "" {
+			continue
+		}
+		result[name] = ( raw[i / 8] & ( 1 << ( uint( i ) % 8 ) ) ) != 0
+	}
+
+	return result
+}
+
+// decodeField interprets raw, which must already be sized according to
+// sizeOf( f ), as a value of f.Type.
+func decodeField( raw []byte, f Field ) ( interface{}, error ) {
+	switch f.Type {
+	case TypeU16:
+		return binary.BigEndian.Uint16( raw ), nil
+	case TypeI16:
+		return int16( binary.BigEndian.Uint16( raw ) ), nil
+	case TypeFixed:
+		scale := f.Scale
+		if scale == 0 {
+			scale = 1
+		}
+
+		return float64( int16( binary.BigEndian.Uint16( raw ) ) ) * scale + f.FixedOffset, nil
+	case TypeBitfield:
+		return decodeBitfield( raw, f.Bits ), nil
+	case TypeASCII:
+		return string( raw ), nil
+	}
+
+	info, ok := numericTypes[f.Type]
+	if !ok {
+		return nil, fmt.Errorf( "unknown type '%s'", f.Type )
+	}
+	canon := ReorderWords( raw, info.wordsReversed, info.bytesSwapped )
+	switch info.width {
+	case 4:
+		bits := binary.BigEndian.Uint32( canon )
+		switch info.kind {
+		case kindUnsigned:
+			return bits, nil
+		case kindSigned:
+			return int32( bits ), nil
+		case kindFloat:
+			return math.Float32frombits( bits ), nil
+		}
+	case 8:
+		bits := binary.BigEndian.Uint64( canon )
+		switch info.kind {
+		case kindUnsigned:
+			return bits, nil
+		case kindSigned:
+			return int64( bits ), nil
+		case kindFloat:
+			return math.Float64frombits( bits ), nil
+		}
+	}
+
+	return nil, fmt.Errorf( "unsupported type '%s'", f.Type )
+}
+
+// Decoder decodes the raw register bytes of one modbus command into a
+// named, typed map, according to a fixed schema of Fields.
+type Decoder struct {
+	fields []Field
+}
+
+// NewDecoder builds a Decoder from fields, validating that every name is
+// unique and non-empty and that every Type/Length combination is
+// well-formed.
+func NewDecoder( fields []Field ) ( *Decoder, error ) {
+	seen := make( map[string]struct{}, len( fields ) )
+	for _, f := range fields {
+		if f.Name == "" {
+			return nil, errors.New( "field name must not be empty" )
+		}
+		if _, ok := seen[f.Name]; ok {
+			return nil, fmt.Errorf( "duplicate field name '%s'", f.Name )
+		}
+		seen[f.Name] = struct{}{}
+		if _, err := sizeOf( f ); err != nil {
+			return nil, fmt.Errorf( "field '%s': %v", f.Name, err )
+		}
+	}
+
+	return &Decoder{ fields: fields }, nil
+}
+
+// Decode produces the typed map described by d's schema out of data,
+// which is the raw register data of the command the schema was declared
+// for.
+func ( d *Decoder ) Decode( data []byte ) ( map[string]interface{}, error ) {
+	result := make( map[string]interface{}, len( d.fields ) )
+	for _, f := range d.fields {
+		size, err := sizeOf( f )
+		if err != nil {
+			return nil, fmt.Errorf( "field '%s': %v", f.Name, err )
+		}
+		start := 2 * f.Offset
+		end := start + size
+		if uint( len( data ) ) < end {
+			return nil, fmt.Errorf( "field '%s': offset %d and/or size %d out of bounds (data length %d)", f.Name, f.Offset, size, len( data ) )
+		}
+		value, err := decodeField( data[start:end], f )
+		if err != nil {
+			return nil, fmt.Errorf( "field '%s': %v", f.Name, err )
+		}
+		result[f.Name] = value
+	}
+
+	return result, nil
+}
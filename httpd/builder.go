@@ -26,6 +26,7 @@ import(
 	"errors"
 	"fmt"
 	"github.com/TheCount/goodbus/builder"
+	"github.com/TheCount/goodbus/decode"
 	"io"
 	"math"
 	"time"
@@ -34,16 +35,40 @@ import(
 const(
 	kBitfield = "bitfield"
 	kBitmap = "bitmap"
+	kBits = "bits"
+	kEnum = "enum"
+	kFloat32 = "float32"
+	kFloat64 = "float64"
 	kInt16 = "int16"
+	kInt32 = "int32"
 	kLength = "length"
+	kName = "name"
 	kNumber = "number"
 	kOffset = "offset"
 	kScaler = "scaler"
+	kSigned = "signed"
+	kString = "string"
 	kTime = "time"
 	kUInt16 = "uint16"
+	kUInt32 = "uint32"
 	kValue = "value"
+	kWordOrder = "wordorder"
+
+	// vWordOrderABCD, vWordOrderBADC, vWordOrderCDAB and vWordOrderDCBA
+	// name the four register/byte orderings multi-register Modbus
+	// values are commonly transmitted in: the letters denote the byte
+	// of a big-endian, word-ordered value that ends up in each wire
+	// position. vWordOrderABCD is the default.
+	vWordOrderABCD = "abcd"
+	vWordOrderBADC = "badc"
+	vWordOrderCDAB = "cdab"
+	vWordOrderDCBA = "dcba"
 )
 
+// safeFloatInt is the largest (and, negated, the smallest) integer
+// exactly representable as a float64.
+const safeFloatInt = 1 << 53
+
 // buildFunc is a function type for functions that build values
 // from binary data and configurations.
 type buildFunc func( data []byte, conf config ) ( builder.Dict, error )
@@ -67,9 +92,9 @@ func extractNumber( value builder.Object, conf config ) ( float64, error ) {
 	var scaled float64
 	switch v := value.( type ) {
 	case builder.UInt:
-		scaled = float64( v ) / scaler // FIXME: special considerations for large v
+		scaled = clampToFloat( float64( v ) ) / scaler
 	case builder.Int:
-		scaled = float64( v ) / scaler // FIXME: special considerations for large v
+		scaled = clampToFloat( float64( v ) ) / scaler
 	case builder.Float:
 		scaled = float64( v ) / scaler
 	default:
@@ -79,7 +104,147 @@ func extractNumber( value builder.Object, conf config ) ( float64, error ) {
 	return scaled, nil
 }
 
-// buildBitfield builds a value for a bitfield
+// clampToFloat clamps f to +/- safeFloatInt. builder.Int and builder.UInt
+// are 64 bit, so converting one to float64 can silently lose low-order
+// bits once its magnitude passes safeFloatInt; every caller of
+// extractNumber already bounds-checks its result against a type far
+// narrower than int64/uint64, so clamping here still yields a result
+// that is correctly rejected as out of range, instead of garbage bits
+// leaking into that check.
+func clampToFloat( f float64 ) float64 {
+	if f > safeFloatInt {
+		return safeFloatInt
+	}
+	if f < -safeFloatInt {
+		return -safeFloatInt
+	}
+
+	return f
+}
+
+// subfieldConf holds the parsed {name, bits, signed, enum} a kBitmap
+// entry carries for a multi-bit subfield, as opposed to the single
+// string naming a single-bit flag.
+type subfieldConf struct {
+	name string
+	bits uint
+	signed bool
+	enum []string
+}
+
+// getSubfieldConf parses a kBitmap dict entry, as opposed to the plain
+// string which names a single flag bit.
+func getSubfieldConf( conf config ) ( subfieldConf, error ) {
+	name, err := conf.GetString( kName )
+	if err != nil {
+		return subfieldConf{}, fmt.Errorf( "Unable to get subfield name: %v", err )
+	}
+	bits, err := conf.GetUInt( kBits )
+	if err != nil {
+		return subfieldConf{}, fmt.Errorf( "Unable to get subfield width for '%s': %v", name, err )
+	}
+	signed, err := conf.GetBoolOrDefault( kSigned, false )
+	if err != nil {
+		return subfieldConf{}, fmt.Errorf( "Unable to get signedness for '%s': %v", name, err )
+	}
+	var enum []string
+	if enumList, err := conf.GetList( kEnum ); err == nil {
+		enum = make( []string, len( enumList ) )
+		for i, item := range enumList {
+			enumName, ok := item.( string )
+			if !ok {
+				return subfieldConf{}, fmt.Errorf( "Enum entry for '%s' must be a string: %v", name, item )
+			}
+			enum[i] = enumName
+		}
+	}
+
+	return subfieldConf{ name: name, bits: bits, signed: signed, enum: enum }, nil
+}
+
+// extractBitSlice reads the bits-wide bit slice of data starting at
+// bit position pos (bit 0 being the least significant bit of byte 0),
+// the same bit numbering buildBitfield's single-bit entries already use.
+func extractBitSlice( data []byte, pos, bits uint ) uint64 {
+	var result uint64
+	for b := uint( 0 ); b != bits; b++ {
+		byteIndex, bitIndex := ( pos + b ) / 8, ( pos + b ) % 8
+		if data[byteIndex] & ( 1 << bitIndex ) != 0 {
+			result |= uint64( 1 ) << b
+		}
+	}
+
+	return result
+}
+
+// orBitSlice ORs the bits-wide value raw into data starting at bit
+// position pos, the inverse of extractBitSlice. data is assumed to
+// already be zeroed at every bit position raw is written to.
+func orBitSlice( data []byte, pos, bits uint, raw uint64 ) {
+	for b := uint( 0 ); b != bits; b++ {
+		if raw & ( uint64( 1 ) << b ) != 0 {
+			byteIndex, bitIndex := ( pos + b ) / 8, ( pos + b ) % 8
+			data[byteIndex] |= 1 << bitIndex
+		}
+	}
+}
+
+// buildSubfieldValue turns the raw bits extracted for a subfield into
+// a builder.String mapped through sf.enum if sf.enum is set and raw is
+// in range, or otherwise a builder.Int or builder.UInt according to
+// sf.signed.
+func buildSubfieldValue( raw uint64, sf subfieldConf ) builder.Object {
+	if sf.enum != nil && raw < uint64( len( sf.enum ) ) {
+		return builder.String( sf.enum[raw] )
+	}
+	if sf.signed && sf.bits > 0 && sf.bits < 64 && raw & ( uint64( 1 ) << ( sf.bits - 1 ) ) != 0 {
+		return builder.Int( int64( raw ) - ( int64( 1 ) << sf.bits ) )
+	}
+	if sf.signed {
+		return builder.Int( raw )
+	}
+
+	return builder.UInt( raw )
+}
+
+// subfieldRawValue reverses buildSubfieldValue: it recovers the raw,
+// unsigned bit pattern a subfield's builder.Object value corresponds
+// to, looking entry up in sf.enum first if sf.enum is set.
+func subfieldRawValue( entry builder.Object, sf subfieldConf ) ( uint64, error ) {
+	if sf.enum != nil {
+		if str, ok := entry.( builder.String ); ok {
+			for i, name := range sf.enum {
+				if name == string( str ) {
+					return uint64( i ), nil
+				}
+			}
+
+			return 0, fmt.Errorf( "Unknown enum value '%s' for subfield '%s'", str, sf.name )
+		}
+	}
+	mask := ( uint64( 1 ) << sf.bits ) - 1
+	if sf.signed {
+		iv, ok := entry.( builder.Int )
+		if !ok {
+			return 0, fmt.Errorf( "Subfield '%s' must have an integer value: %v", sf.name, entry )
+		}
+
+		return uint64( iv ) & mask, nil
+	}
+	uv, ok := entry.( builder.UInt )
+	if !ok {
+		return 0, fmt.Errorf( "Subfield '%s' must have an unsigned integer value: %v", sf.name, entry )
+	}
+
+	return uint64( uv ) & mask, nil
+}
+
+// buildBitfield builds a value for a bitfield. Each kBitmap entry is
+// either a plain string, naming a single flag bit at that entry's
+// position, or a dict {name, bits, signed, enum} describing a
+// multi-bit subfield starting at that position; the positions a
+// multi-bit subfield occupies beyond its first are expected to be nil
+// placeholders in the list.
 func buildBitfield( data []byte, conf config, length uint ) ( builder.Dict, error ) {
 	result := builder.NewDict()
 	result[kType] = kBitfield
@@ -90,17 +255,28 @@ func buildBitfield( data []byte, conf config, length uint ) ( builder.Dict, erro
 		return nil, fmt.Errorf( "Unable to get bitmap for bitfield: %v", err )
 	}
 	for i, item := range list {
-		if uint( i ) >= length {
-			return nil, fmt.Errorf( "Bitmap entry out of bounds (length: %v)", length )
-		}
 		if item == nil {
 			continue
 		}
-		name, ok := item.( string )
-		if !ok {
-			return nil, fmt.Errorf( "Bitmap entry name must be a string: %v", item )
+		switch v := item.( type ) {
+		case string:
+			if uint( i ) >= length {
+				return nil, fmt.Errorf( "Bitmap entry out of bounds (length: %v)", length )
+			}
+			bitmap[v] = builder.Bool( ( data[i / 8] & ( 1 << ( uint( i ) % 8 ) ) ) != 0 )
+		case config:
+			sf, err := getSubfieldConf( v )
+			if err != nil {
+				return nil, fmt.Errorf( "Invalid bitmap subfield entry: %v", err )
+			}
+			if uint( i ) + sf.bits > length {
+				return nil, fmt.Errorf( "Bitmap subfield '%s' out of bounds (length: %v)", sf.name, length )
+			}
+			raw := extractBitSlice( data, uint( i ), sf.bits )
+			bitmap[sf.name] = buildSubfieldValue( raw, sf )
+		default:
+			return nil, fmt.Errorf( "Bitmap entry must be a string or a subfield dictionary: %v", item )
 		}
-		bitmap[name] = builder.Bool( ( data[i / 8] & ( 1 << ( uint( i ) % 8 ) ) ) != 0 )
 	}
 	result[kValue] = bitmap
 
@@ -132,28 +308,47 @@ func serialiseBitfield( data []byte, value builder.Object, conf config, length u
 		return fmt.Errorf( "Unable to get bitmap configuration for %+v: %v", dict, err )
 	}
 	for i, item := range list {
-		if uint( i ) >= length {
-			return fmt.Errorf( "Bitmap entry out of bounds (length: %v)", length )
-		}
 		if item == nil {
 			continue
 		}
-		name, ok := item.( string )
-		if !ok {
-			return fmt.Errorf( "Bitmap entry name must be a string: %v", item )
+		switch v := item.( type ) {
+		case string:
+			if uint( i ) >= length {
+				return fmt.Errorf( "Bitmap entry out of bounds (length: %v)", length )
+			}
+			entry, ok := dict[v]
+			if !ok {
+				return fmt.Errorf( "Mandatory bitmap entry '%v' not found", v )
+			}
+			boolv, ok := entry.( builder.Bool )
+			if !ok {
+				return fmt.Errorf( "Bitmap entry '%v' must have a boolean value", v )
+			}
+			if boolv {
+				data[i / 8] |= 1 << ( uint( i ) % 8 )
+			}
+			delete( dict, v )
+		case config:
+			sf, err := getSubfieldConf( v )
+			if err != nil {
+				return fmt.Errorf( "Invalid bitmap subfield entry: %v", err )
+			}
+			if uint( i ) + sf.bits > length {
+				return fmt.Errorf( "Bitmap subfield '%s' out of bounds (length: %v)", sf.name, length )
+			}
+			entry, ok := dict[sf.name]
+			if !ok {
+				return fmt.Errorf( "Mandatory bitmap subfield '%s' not found", sf.name )
+			}
+			raw, err := subfieldRawValue( entry, sf )
+			if err != nil {
+				return fmt.Errorf( "Invalid value for bitmap subfield '%s': %v", sf.name, err )
+			}
+			orBitSlice( data, uint( i ), sf.bits, raw )
+			delete( dict, sf.name )
+		default:
+			return fmt.Errorf( "Bitmap entry must be a string or a subfield dictionary: %v", item )
 		}
-		entry, ok := dict[name]
-		if !ok {
-			return fmt.Errorf( "Mandatory bitmap entry '%v' not found", name )
-		}
-		boolv, ok := entry.( builder.Bool )
-		if !ok {
-			return fmt.Errorf( "Bitmap entry '%v' must have a boolean value", name )
-		}
-		if boolv {
-			data[i / 8] |= 1 << ( uint( i ) % 8 )
-		}
-		delete( dict, name )
 	}
 	if len( dict ) > 0 {
 		return fmt.Errorf( "Unable to assign unsupported entries: %+v", dict )
@@ -246,6 +441,179 @@ func serialiseUInt16( data []byte, value builder.Object, conf config, unused uin
 	return nil
 }
 
+// getWordOrder reads the kWordOrder configuration entry, if any, and
+// returns the register and intra-register byte reordering it describes,
+// defaulting to vWordOrderABCD when absent.
+func getWordOrder( conf config ) ( wordsReversed, bytesSwapped bool, err error ) {
+	order, err := conf.GetStringOrDefault( kWordOrder, vWordOrderABCD )
+	if err != nil {
+		return false, false, fmt.Errorf( "Unable to obtain word order: %v", err )
+	}
+	switch order {
+	case vWordOrderABCD:
+		return false, false, nil
+	case vWordOrderBADC:
+		return false, true, nil
+	case vWordOrderCDAB:
+		return true, false, nil
+	case vWordOrderDCBA:
+		return true, true, nil
+	}
+
+	return false, false, fmt.Errorf( "Unknown word order '%v'", order )
+}
+
+// notSerialisable returns a serialise function for a build-only type
+// that unconditionally reports typeName as not supporting serialisation.
+func notSerialisable( typeName string ) func( []byte, builder.Object, config, uint ) error {
+	return func( data []byte, obj builder.Object, conf config, length uint ) error {
+		return fmt.Errorf( "Type '%s' does not support serialisation", typeName )
+	}
+}
+
+// buildInt32 builds a 32 bit signed integer value
+func buildInt32( data []byte, conf config, unused uint ) ( builder.Dict, error ) {
+	result := builder.NewDict()
+	result[kType] = kNumber
+	scaler, err := conf.GetFloatOrDefault( kScaler, 1.0 )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to obtain scaler for 32 bit integer: %v", err )
+	}
+	wordsReversed, bytesSwapped, err := getWordOrder( conf )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to obtain word order for 32 bit integer: %v", err )
+	}
+	canon := decode.ReorderWords( data, wordsReversed, bytesSwapped )
+	dw := ( uint32( canon[0] ) << 24 ) | ( uint32( canon[1] ) << 16 ) | ( uint32( canon[2] ) << 8 ) | uint32( canon[3] )
+	var value float64
+	if ( dw & 0x80000000 ) != 0 {
+		value = -float64( ^dw ) - 1.0
+	} else {
+		value = float64( dw )
+	}
+	result[kValue] = builder.Float( value * scaler )
+
+	return result, nil
+}
+
+// serialiseInt32 serialises a value into a 32 bit 2's complement integer value
+func serialiseInt32( data []byte, value builder.Object, conf config, unused uint ) error {
+	// Sanity check
+	if len( data ) < 4 {
+		return fmt.Errorf( "Invalid data length for 32 bit integer: %v", len( data ) )
+	}
+
+	// Serialise
+	scaled, err := extractNumber( value, conf )
+	if err != nil {
+		return fmt.Errorf( "Unable to extract numeric value from %+v: %v", value, err )
+	}
+	if scaled >= math.MaxInt32 + 0.5 || scaled <= math.MinInt32 - 0.5 {
+		return fmt.Errorf( "Scaled value out of bounds: %v", scaled )
+	}
+	var intv int32
+	if scaled >= 0 {
+		intv = int32( scaled + 0.5 )
+	} else {
+		intv = int32( scaled - 0.5 )
+	}
+	uintv := uint32( intv )
+	canon := []byte{ byte( uintv >> 24 ), byte( ( uintv >> 16 ) & 0xFF ), byte( ( uintv >> 8 ) & 0xFF ), byte( uintv & 0xFF ) }
+	wordsReversed, bytesSwapped, err := getWordOrder( conf )
+	if err != nil {
+		return fmt.Errorf( "Unable to obtain word order for 32 bit integer: %v", err )
+	}
+	copy( data, decode.ReorderWords( canon, wordsReversed, bytesSwapped ) )
+
+	return nil
+}
+
+// buildUInt32 builds a 32 bit unsigned integer value
+func buildUInt32( data []byte, conf config, unused uint ) ( builder.Dict, error ) {
+	result := builder.NewDict()
+	result[kType] = kNumber
+	scaler, err := conf.GetFloatOrDefault( kScaler, 1.0 )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to obtain scaler for 32 bit unsigned integer: %v", err )
+	}
+	wordsReversed, bytesSwapped, err := getWordOrder( conf )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to obtain word order for 32 bit unsigned integer: %v", err )
+	}
+	canon := decode.ReorderWords( data, wordsReversed, bytesSwapped )
+	value := float64( ( uint32( canon[0] ) << 24 ) | ( uint32( canon[1] ) << 16 ) | ( uint32( canon[2] ) << 8 ) | uint32( canon[3] ) )
+	result[kValue] = builder.Float( value * scaler )
+
+	return result, nil
+}
+
+// buildFloat32 builds an IEEE 754 single precision floating-point value
+func buildFloat32( data []byte, conf config, unused uint ) ( builder.Dict, error ) {
+	result := builder.NewDict()
+	result[kType] = kNumber
+	wordsReversed, bytesSwapped, err := getWordOrder( conf )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to obtain word order for 32 bit float: %v", err )
+	}
+	canon := decode.ReorderWords( data, wordsReversed, bytesSwapped )
+	bits := ( uint32( canon[0] ) << 24 ) | ( uint32( canon[1] ) << 16 ) | ( uint32( canon[2] ) << 8 ) | uint32( canon[3] )
+	result[kValue] = builder.Float( math.Float32frombits( bits ) )
+
+	return result, nil
+}
+
+// buildFloat64 builds an IEEE 754 double precision floating-point value
+func buildFloat64( data []byte, conf config, unused uint ) ( builder.Dict, error ) {
+	result := builder.NewDict()
+	result[kType] = kNumber
+	wordsReversed, bytesSwapped, err := getWordOrder( conf )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to obtain word order for 64 bit float: %v", err )
+	}
+	canon := decode.ReorderWords( data, wordsReversed, bytesSwapped )
+	var bits uint64
+	for _, b := range canon {
+		bits = ( bits << 8 ) | uint64( b )
+	}
+	result[kValue] = builder.Float( math.Float64frombits( bits ) )
+
+	return result, nil
+}
+
+// buildString builds a fixed-length ASCII string value out of length bytes
+func buildString( data []byte, conf config, length uint ) ( builder.Dict, error ) {
+	result := builder.NewDict()
+	result[kType] = kString
+	result[kLength] = builder.UInt( length )
+	result[kValue] = builder.String( string( data ) )
+
+	return result, nil
+}
+
+// serialiseString serialises a string value into length bytes, zero-padding
+// any bytes beyond the string's own length
+func serialiseString( data []byte, value builder.Object, conf config, length uint ) error {
+	// Sanity check
+	if uint( len( data ) ) < length {
+		return fmt.Errorf( "Data length %v too short to hold string of length %v", len( data ), length )
+	}
+	str, ok := value.( builder.String )
+	if !ok {
+		return errors.New( "String value must be a string" )
+	}
+	if uint( len( str ) ) > length {
+		return fmt.Errorf( "String value '%v' exceeds configured length %v", str, length )
+	}
+
+	// Serialise, zero-padding the remainder
+	copy( data, []byte( str ) )
+	for i := uint( len( str ) ); i != length; i++ {
+		data[i] = 0
+	}
+
+	return nil
+}
+
 // extractInfo extracts offset and type information from a values configuration
 func extractInfo( conf config ) ( uint, uint, buildFunc, serialiseFunc, error ) {
 	// TypeInfo carries information about the types supported
@@ -254,6 +622,11 @@ func extractInfo( conf config ) ( uint, uint, buildFunc, serialiseFunc, error )
 		// A value of zero means variable size.
 		size uint
 
+		// lengthInBytes says whether a variable-size type's kLength is
+		// already a byte count. If false, kLength is a bit count and the
+		// byte size is rounded up from it instead.
+		lengthInBytes bool
+
 		// build builds a value for the type
 		build func( data []byte, conf config, length uint ) ( builder.Dict, error )
 
@@ -262,9 +635,14 @@ func extractInfo( conf config ) ( uint, uint, buildFunc, serialiseFunc, error )
 	}
 
 	var typeInfoMap = map[string]typeInfo{
-		kBitfield: { 0, buildBitfield, serialiseBitfield },
-		kInt16: { 2, buildInt16, serialiseInt16 },
-		kUInt16: { 2, buildUInt16, serialiseUInt16 },
+		kBitfield: { 0, false, buildBitfield, serialiseBitfield },
+		kInt16: { 2, false, buildInt16, serialiseInt16 },
+		kUInt16: { 2, false, buildUInt16, serialiseUInt16 },
+		kInt32: { 4, false, buildInt32, serialiseInt32 },
+		kUInt32: { 4, false, buildUInt32, notSerialisable( kUInt32 ) },
+		kFloat32: { 4, false, buildFloat32, notSerialisable( kFloat32 ) },
+		kFloat64: { 8, false, buildFloat64, notSerialisable( kFloat64 ) },
+		kString: { 0, true, buildString, serialiseString },
 	}
 
 	offset, err := conf.GetUInt( kOffset )
@@ -288,7 +666,11 @@ func extractInfo( conf config ) ( uint, uint, buildFunc, serialiseFunc, error )
 		if err != nil {
 			return 0, 0, nil, nil, fmt.Errorf( "Unable to extract mandatory length for type '%v': %v", typ, err )
 		}
-		size = ( length + 7 ) / 8 // size = length in bits as bytes, rounded up
+		if info.lengthInBytes {
+			size = length
+		} else {
+			size = ( length + 7 ) / 8 // size = length in bits as bytes, rounded up
+		}
 	}
 
 	return offset, size, func( data []byte, conf config ) ( builder.Dict, error ) {
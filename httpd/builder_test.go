@@ -0,0 +1,226 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import(
+	"bytes"
+	"github.com/TheCount/goodbus/builder"
+	"github.com/TheCount/goodbus/decode"
+	"testing"
+)
+
+func TestGetWordOrder( t *testing.T ) {
+	cases := []struct{
+		order string
+		wordsReversed bool
+		bytesSwapped bool
+	}{
+		{ "", false, false },
+		{ vWordOrderABCD, false, false },
+		{ vWordOrderBADC, false, true },
+		{ vWordOrderCDAB, true, false },
+		{ vWordOrderDCBA, true, true },
+	}
+	for _, c := range cases {
+		conf := config{}
+		if c.order != "" {
+			conf[kWordOrder] = c.order
+		}
+		wordsReversed, bytesSwapped, err := getWordOrder( conf )
+		if err != nil {
+			t.Errorf( "order '%s': unexpected error: %v", c.order, err )
+			continue
+		}
+		if wordsReversed != c.wordsReversed || bytesSwapped != c.bytesSwapped {
+			t.Errorf( "order '%s': got (%v, %v), want (%v, %v)", c.order, wordsReversed, bytesSwapped, c.wordsReversed, c.bytesSwapped )
+		}
+	}
+
+	if _, _, err := getWordOrder( config{ kWordOrder: "nonsense" } ); err == nil {
+		t.Error( "Expected error for unknown word order" )
+	}
+}
+
+func TestReorderWords( t *testing.T ) {
+	raw := []byte{ 0x11, 0x22, 0x33, 0x44 }
+	cases := []struct{
+		name string
+		wordsReversed bool
+		bytesSwapped bool
+		want []byte
+	}{
+		{ "abcd", false, false, []byte{ 0x11, 0x22, 0x33, 0x44 } },
+		{ "badc", false, true, []byte{ 0x22, 0x11, 0x44, 0x33 } },
+		{ "cdab", true, false, []byte{ 0x33, 0x44, 0x11, 0x22 } },
+		{ "dcba", true, true, []byte{ 0x44, 0x33, 0x22, 0x11 } },
+	}
+	for _, c := range cases {
+		got := decode.ReorderWords( raw, c.wordsReversed, c.bytesSwapped )
+		if !bytes.Equal( got, c.want ) {
+			t.Errorf( "%s: got %v, want %v", c.name, got, c.want )
+		}
+	}
+}
+
+func TestReorderWordsRoundTrip( t *testing.T ) {
+	raw := []byte{ 0xDE, 0xAD, 0xBE, 0xEF }
+	for _, wordsReversed := range []bool{ false, true } {
+		for _, bytesSwapped := range []bool{ false, true } {
+			reordered := decode.ReorderWords( raw, wordsReversed, bytesSwapped )
+			back := decode.ReorderWords( reordered, wordsReversed, bytesSwapped )
+			if !bytes.Equal( back, raw ) {
+				t.Errorf( "wordsReversed=%v bytesSwapped=%v: round trip got %v, want %v", wordsReversed, bytesSwapped, back, raw )
+			}
+		}
+	}
+}
+
+func TestExtractOrBitSliceRoundTrip( t *testing.T ) {
+	cases := []struct{
+		pos uint
+		bits uint
+		raw uint64
+	}{
+		{ 0, 1, 1 },
+		{ 3, 4, 0xA },
+		{ 7, 2, 0x3 },
+		{ 1, 9, 0x1FF },
+	}
+	for _, c := range cases {
+		data := make( []byte, 4 )
+		orBitSlice( data, c.pos, c.bits, c.raw )
+		got := extractBitSlice( data, c.pos, c.bits )
+		if got != c.raw {
+			t.Errorf( "pos=%d bits=%d: got %#x, want %#x", c.pos, c.bits, got, c.raw )
+		}
+	}
+}
+
+func TestBuildSubfieldValue( t *testing.T ) {
+	enumSf := subfieldConf{ name: "state", bits: 2, enum: []string{ "off", "on", "fault" } }
+	if v := buildSubfieldValue( 1, enumSf ); v != builder.String( "on" ) {
+		t.Errorf( "enum in range: got %v, want %v", v, builder.String( "on" ) )
+	}
+	if v := buildSubfieldValue( 3, enumSf ); v != builder.UInt( 3 ) {
+		t.Errorf( "enum out of range: got %v, want %v", v, builder.UInt( 3 ) )
+	}
+
+	unsignedSf := subfieldConf{ name: "count", bits: 4 }
+	if v := buildSubfieldValue( 9, unsignedSf ); v != builder.UInt( 9 ) {
+		t.Errorf( "unsigned: got %v, want %v", v, builder.UInt( 9 ) )
+	}
+
+	signedSf := subfieldConf{ name: "delta", bits: 4, signed: true }
+	if v := buildSubfieldValue( 0xF, signedSf ); v != builder.Int( -1 ) {
+		t.Errorf( "signed negative: got %v, want %v", v, builder.Int( -1 ) )
+	}
+	if v := buildSubfieldValue( 0x3, signedSf ); v != builder.Int( 3 ) {
+		t.Errorf( "signed positive: got %v, want %v", v, builder.Int( 3 ) )
+	}
+}
+
+func TestSubfieldRawValueRoundTrip( t *testing.T ) {
+	enumSf := subfieldConf{ name: "state", bits: 2, enum: []string{ "off", "on", "fault" } }
+	signedSf := subfieldConf{ name: "delta", bits: 4, signed: true }
+	unsignedSf := subfieldConf{ name: "count", bits: 4 }
+
+	for _, raw := range []uint64{ 0, 1, 2 } {
+		value := buildSubfieldValue( raw, enumSf )
+		got, err := subfieldRawValue( value, enumSf )
+		if err != nil {
+			t.Errorf( "enum raw %d: unexpected error: %v", raw, err )
+			continue
+		}
+		if got != raw {
+			t.Errorf( "enum raw %d: round trip got %d", raw, got )
+		}
+	}
+	for _, raw := range []uint64{ 0x0, 0x3, 0xF } {
+		value := buildSubfieldValue( raw, signedSf )
+		got, err := subfieldRawValue( value, signedSf )
+		if err != nil {
+			t.Errorf( "signed raw %#x: unexpected error: %v", raw, err )
+			continue
+		}
+		if got != raw {
+			t.Errorf( "signed raw %#x: round trip got %#x", raw, got )
+		}
+	}
+	for _, raw := range []uint64{ 0x0, 0x9, 0xF } {
+		value := buildSubfieldValue( raw, unsignedSf )
+		got, err := subfieldRawValue( value, unsignedSf )
+		if err != nil {
+			t.Errorf( "unsigned raw %#x: unexpected error: %v", raw, err )
+			continue
+		}
+		if got != raw {
+			t.Errorf( "unsigned raw %#x: round trip got %#x", raw, got )
+		}
+	}
+
+	if _, err := subfieldRawValue( builder.String( "unknown" ), enumSf ); err == nil {
+		t.Error( "Expected error for unknown enum value" )
+	}
+}
+
+func TestBuildAndSerialiseBitfieldRoundTrip( t *testing.T ) {
+	bitmapConf := []interface{}{
+		"flag0",
+		nil,
+		config{ kName: "mode", kBits: uint( 3 ), kEnum: []interface{}{ "idle", "run", "fault" } },
+		nil,
+		nil,
+		"flag5",
+	}
+	conf := config{ kBitmap: bitmapConf }
+	data := []byte{ 0, 0 }
+	data[0] |= 1 << 0 // flag0, bit position 0
+	data[0] |= 1 << 2 // mode, bit positions 2-4, value 1 ("run")
+	data[0] |= 1 << 5 // flag5, bit position 5
+
+	dict, err := buildBitfield( data, conf, 8 )
+	if err != nil {
+		t.Fatalf( "Unable to build bitfield: %v", err )
+	}
+	value, ok := dict[kValue].( builder.Dict )
+	if !ok {
+		t.Fatalf( "Bitfield value is not a dictionary: %+v", dict[kValue] )
+	}
+	if value["flag0"] != builder.Bool( true ) {
+		t.Errorf( "flag0: got %v, want true", value["flag0"] )
+	}
+	if value["flag5"] != builder.Bool( true ) {
+		t.Errorf( "flag5: got %v, want true", value["flag5"] )
+	}
+	if value["mode"] != builder.String( "run" ) {
+		t.Errorf( "mode: got %v, want 'run'", value["mode"] )
+	}
+
+	out := make( []byte, 2 )
+	if err := serialiseBitfield( out, dict[kValue], conf, 8 ); err != nil {
+		t.Fatalf( "Unable to serialise bitfield: %v", err )
+	}
+	if !bytes.Equal( out, data ) {
+		t.Errorf( "Round trip got %v, want %v", out, data )
+	}
+}
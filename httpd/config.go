@@ -104,6 +104,20 @@ func ( c config ) GetIntOrDefault( name string, dflt int ) ( int, error ) {
 	return result, nil
 }
 
+// GetFloatOrDefault gets a floating-point value or a default value from a config.
+func ( c config ) GetFloatOrDefault( name string, dflt float64 ) ( float64, error ) {
+	item, ok := c[name]
+	if !ok {
+		return dflt, nil
+	}
+	result, err := cast.ToFloat64E( item )
+	if err != nil {
+		return 0, fmt.Errorf( "Item '%s' is not a floating-point value", name );
+	}
+
+	return result, nil
+}
+
 // GetUInt8OrDefault gets an unsigned 8-bit integer
 // or a default value from a config.
 func ( c config ) GetUInt8OrDefault( name string, dflt uint8 ) ( uint8, error ) {
@@ -119,6 +133,20 @@ func ( c config ) GetUInt8OrDefault( name string, dflt uint8 ) ( uint8, error )
 	return result, nil
 }
 
+// GetUInt gets an unsigned integer from a config.
+func ( c config ) GetUInt( name string ) ( uint, error ) {
+	item, ok := c[name]
+	if !ok {
+		return 0, fmt.Errorf( "Unsigned integer '%s' not found", name )
+	}
+	result, err := cast.ToUintE( item )
+	if err != nil {
+		return 0, fmt.Errorf( "Item '%s' is not an unsigned integer", name )
+	}
+
+	return result, nil
+}
+
 // GetUInt16 gets an unsigned 16-bit integer from a config.
 func ( c config ) GetUInt16( name string ) ( uint16, error ) {
 	item, ok := c[name]
@@ -133,6 +161,20 @@ func ( c config ) GetUInt16( name string ) ( uint16, error ) {
 	return result, nil
 }
 
+// GetList gets a list of values from a config.
+func ( c config ) GetList( name string ) ( []interface{}, error ) {
+	item, ok := c[name]
+	if !ok {
+		return nil, fmt.Errorf( "List '%s' not found", name )
+	}
+	result, err := cast.ToSliceE( item )
+	if err != nil {
+		return nil, fmt.Errorf( "Item '%s' is not a list", name )
+	}
+
+	return result, nil
+}
+
 // GetString gets a string from a config.
 func ( c config ) GetString( name string ) ( string, error ) {
 	item, ok := c[name]
@@ -147,6 +189,20 @@ func ( c config ) GetString( name string ) ( string, error ) {
 	return result, nil
 }
 
+// GetStringOrDefault gets a string from a config, or a default value.
+func ( c config ) GetStringOrDefault( name string, dflt string ) ( string, error ) {
+	item, ok := c[name]
+	if !ok {
+		return dflt, nil
+	}
+	result, ok := item.( string )
+	if !ok {
+		return "<error>", fmt.Errorf( "Item '%s' is not a string", name )
+	}
+
+	return result, nil
+}
+
 // GetDurationOrDefault gets a duration from a config,
 // or the specified default value if it is not found.
 func ( c config ) GetDurationOrDefault( name string, dflt time.Duration ) ( time.Duration, error ) {
@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import(
+	"encoding/json"
+	"fmt"
+	"github.com/TheCount/goodbus/configwatch"
+	"log"
+	"path"
+	"time"
+)
+
+// configuration keys for the optional configwatch section nested under
+// the scheduler configuration.
+const(
+	kConfigWatch = "configwatch"
+	kConfigWatchEndpoints = "endpoints"
+	kConfigWatchPrefix = "prefix"
+)
+
+// dConfigWatchDialTimeout bounds how long startConfigWatch waits to
+// connect to etcd.
+const dConfigWatchDialTimeout = 5 * time.Second
+
+// WatchCommands applies configuration changes read from source to
+// sched as they arrive: a put event (re-)adds the command named by the
+// final path segment of the key, a delete event removes it. It runs
+// until source's event channel closes.
+func WatchCommands( source configwatch.Source, sched *scheduler ) {
+	for event := range source.Events() {
+		name := path.Base( event.Key )
+		switch event.Type {
+		case configwatch.EventPut:
+			var commandConf config
+			if err := json.Unmarshal( event.Value, &commandConf ); err != nil {
+				log.Printf( "Unable to decode configuration pushed for command '%s': %v", name, err )
+				continue
+			}
+			if sched.hasCommand( name ) {
+				if err := sched.RemoveCommand( name ); err != nil {
+					log.Printf( "Unable to remove command '%s' before reconfiguring: %v", name, err )
+					continue
+				}
+			}
+			if err := sched.AddCommand( name, commandConf ); err != nil {
+				log.Printf( "Unable to add command '%s' from configuration watch: %v", name, err )
+			}
+
+		case configwatch.EventDelete:
+			if !sched.hasCommand( name ) {
+				continue
+			}
+			if err := sched.RemoveCommand( name ); err != nil {
+				log.Printf( "Unable to remove command '%s' from configuration watch: %v", name, err )
+			}
+		}
+	}
+}
+
+// startConfigWatch wires an etcd-backed configwatch.Source into sched
+// according to conf, so commands/* keys under the configured prefix
+// can be added, removed, or changed at runtime without a process
+// restart.
+func startConfigWatch( conf config, sched *scheduler ) error {
+	endpointList, err := conf.GetList( kConfigWatchEndpoints )
+	if err != nil {
+		return fmt.Errorf( "Unable to read etcd endpoints: %v", err )
+	}
+	endpoints := make( []string, len( endpointList ) )
+	for i, item := range endpointList {
+		endpoint, ok := item.( string )
+		if !ok {
+			return fmt.Errorf( "etcd endpoint '%v' is not a string", item )
+		}
+		endpoints[i] = endpoint
+	}
+	prefix, err := conf.GetString( kConfigWatchPrefix )
+	if err != nil {
+		return fmt.Errorf( "Unable to read etcd key prefix: %v", err )
+	}
+	source, err := configwatch.NewEtcdSource( endpoints, prefix, dConfigWatchDialTimeout )
+	if err != nil {
+		return fmt.Errorf( "Unable to start etcd configuration watch: %v", err )
+	}
+	go WatchCommands( source, sched )
+
+	return nil
+}
@@ -0,0 +1,188 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import(
+	"encoding/json"
+	"fmt"
+	"github.com/TheCount/goodbus/sched"
+	"log"
+	"net/http"
+	"time"
+)
+
+// dReadyFailureThreshold is the number of consecutive command
+// execution failures readyzHandler tolerates before reporting the
+// process as not ready.
+const dReadyFailureThreshold = 3
+
+// dHealthPollInterval is how often healthStreamHandler checks for a
+// status transition to report to a long-polling/SSE client.
+const dHealthPollInterval = time.Second
+
+// commandHealth is the JSON representation of a sched.CommandStatus.
+type commandHealth struct {
+	Status string `json:"status"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// toCommandHealth converts a sched.CommandStatus to its JSON
+// representation.
+func toCommandHealth( status sched.CommandStatus ) commandHealth {
+	result := commandHealth{ Status: status.Status.String() }
+	if !status.LastSuccess.IsZero() {
+		t := status.LastSuccess
+		result.LastSuccess = &t
+	}
+	if !status.LastFailure.IsZero() {
+		t := status.LastFailure
+		result.LastFailure = &t
+	}
+	if status.LastErr != nil {
+		result.Error = status.LastErr.Error()
+	}
+
+	return result
+}
+
+// writeHealthJSON writes obj as the JSON body of a response with the
+// given status code.
+func writeHealthJSON( w http.ResponseWriter, code int, obj interface{} ) {
+	w.Header().Set( "Content-Type", "application/json" )
+	w.WriteHeader( code )
+	if err := json.NewEncoder( w ).Encode( obj ); err != nil {
+		log.Printf( "Unable to write health JSON response: %v", err )
+	}
+}
+
+// healthzHandler reports process liveness: whether the scheduler
+// goroutine is running at all. If the command query parameter is set,
+// it instead reports that one command's status, regardless of overall
+// liveness.
+func healthzHandler( s *scheduler ) http.HandlerFunc {
+	return func( w http.ResponseWriter, r *http.Request ) {
+		if name := r.URL.Query().Get( "command" ); name != "" {
+			status, ok := s.Status( name )
+			if !ok {
+				w.WriteHeader( http.StatusNotFound )
+				return
+			}
+			writeHealthJSON( w, http.StatusOK, toCommandHealth( status ) )
+			return
+		}
+		if !s.IsRunning() {
+			writeHealthJSON( w, http.StatusServiceUnavailable, commandHealth{ Status: sched.StatusNotServing.String() } )
+			return
+		}
+		writeHealthJSON( w, http.StatusOK, commandHealth{ Status: sched.StatusServing.String() } )
+	}
+}
+
+// readyzHandler reports readiness: the scheduler is running and no
+// configured command has dReadyFailureThreshold or more consecutive
+// execution failures.
+func readyzHandler( s *scheduler ) http.HandlerFunc {
+	return func( w http.ResponseWriter, r *http.Request ) {
+		if !s.IsRunning() {
+			writeHealthJSON( w, http.StatusServiceUnavailable, commandHealth{ Status: sched.StatusNotServing.String() } )
+			return
+		}
+		for name := range s.commandConfigs() {
+			status, ok := s.Status( name )
+			if ok && status.ConsecutiveFailures >= dReadyFailureThreshold {
+				writeHealthJSON( w, http.StatusServiceUnavailable, map[string]commandHealth{ name: toCommandHealth( status ) } )
+				return
+			}
+		}
+		writeHealthJSON( w, http.StatusOK, commandHealth{ Status: sched.StatusServing.String() } )
+	}
+}
+
+// commandStatusEqual reports whether a and b describe the same
+// command health, field by field. sched.CommandStatus embeds a plain
+// error in LastErr, which may hold a non-comparable concrete type
+// (e.g. an error struct with a slice or map field) whenever Execute
+// comes from a plugin or other out-of-process source; a raw a != b
+// would panic comparing those, so LastErr is compared by its Error()
+// string (and nilness) instead of by value.
+func commandStatusEqual( a, b sched.CommandStatus ) bool {
+	if a.Status != b.Status || !a.LastSuccess.Equal( b.LastSuccess ) || !a.LastFailure.Equal( b.LastFailure ) || a.ConsecutiveFailures != b.ConsecutiveFailures {
+		return false
+	}
+	if ( a.LastErr == nil ) != ( b.LastErr == nil ) {
+		return false
+	}
+
+	return a.LastErr == nil || a.LastErr.Error() == b.LastErr.Error()
+}
+
+// healthStreamHandler streams status transitions for the command named
+// by the command query parameter as newline-delimited Server-Sent
+// Events, instead of requiring the client to poll healthzHandler.
+func healthStreamHandler( s *scheduler ) http.HandlerFunc {
+	return func( w http.ResponseWriter, r *http.Request ) {
+		name := r.URL.Query().Get( "command" )
+		if name == "" {
+			w.WriteHeader( http.StatusBadRequest )
+			return
+		}
+		flusher, ok := w.( http.Flusher )
+		if !ok {
+			w.WriteHeader( http.StatusInternalServerError )
+			log.Print( "Streaming not supported by response writer" )
+			return
+		}
+		w.Header().Set( "Content-Type", "text/event-stream" )
+		w.WriteHeader( http.StatusOK )
+		var last sched.CommandStatus
+		ticker := time.NewTicker( dHealthPollInterval )
+		defer ticker.Stop()
+		for {
+			status, ok := s.Status( name )
+			if !ok {
+				return
+			}
+			if !commandStatusEqual( status, last ) {
+				blob, err := json.Marshal( toCommandHealth( status ) )
+				if err != nil {
+					log.Printf( "Unable to marshal health status event: %v", err )
+					return
+				}
+				if _, err := fmt.Fprintf( w, "data: %s\n\n", blob ); err != nil {
+					log.Printf( "Unable to write health status event: %v", err )
+					return
+				}
+				flusher.Flush()
+				last = status
+			}
+			select {
+			case <-r.Context().Done():
+				return
+
+			case <-ticker.C:
+			}
+		}
+	}
+}
@@ -40,6 +40,7 @@ const(
 	kLocations = "locations"
 	kPath = "path"
 	kHttpTimeout = "timeout"
+	kStream = "stream"
 	kValues = "values"
 )
 
@@ -84,6 +85,59 @@ func ( h readHandler ) ServeHTTP( w http.ResponseWriter, r *http.Request ) {
 	}
 }
 
+// streamHandler is a handler for HTTP requests pertaining to modbus read
+// commands which pushes every new scratchpad value to the client as it
+// arrives, instead of requiring the client to poll with MethodGet like
+// readHandler does. Each pushed value is JSON-encoded exactly like a
+// readHandler response and written as one line of a chunked response
+// body.
+type streamHandler handler
+
+// ServeHTTP streams modbus read command values to the client until the
+// client disconnects.
+func ( h streamHandler ) ServeHTTP( w http.ResponseWriter, r *http.Request ) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader( http.StatusMethodNotAllowed )
+		return
+	}
+	flusher, ok := w.( http.Flusher )
+	if !ok {
+		w.WriteHeader( http.StatusInternalServerError )
+		log.Print( "Streaming not supported by response writer" )
+		return
+	}
+	updates, cancel := h.cc.scratchpad.Subscribe()
+	defer cancel()
+	w.Header().Set( "Content-Type", "application/json" )
+	w.WriteHeader( http.StatusOK )
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			obj, err := buildObject( update.Time, update.Data, h.values )
+			if err != nil {
+				log.Printf( "Cannot build streamed response object: %v", err )
+				return
+			}
+			blob, err := json.Marshal( obj )
+			if err != nil {
+				log.Printf( "Error marshalling JSON (this should not happen): %v", err )
+				return
+			}
+			if _, err = w.Write( append( blob, '\n' ) ); err != nil {
+				log.Printf( "Error writing streamed JSON data to client: %v", err )
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
 // writeHandler is a handler for HTTP requests pertaining to modbus write commands
 type writeHandler handler
 
@@ -129,10 +183,19 @@ func setHandler( locConf config, cc *commandConfig ) error {
 	if err != nil {
 		return fmt.Errorf( "Unable to extract values: %v", err )
 	}
+	stream, err := locConf.GetBoolOrDefault( kStream, false )
+	if err != nil {
+		return fmt.Errorf( "Unable to extract stream flag: %v", err )
+	}
 	h := handler{ cc, values }
-	if cc.IsReadCommand() {
+	switch {
+	case stream && cc.IsReadCommand():
+		http.Handle( path, streamHandler( h ) )
+	case cc.IsReadCommand():
 		http.Handle( path, readHandler( h ) )
-	} else {
+	case stream:
+		return fmt.Errorf( "Streaming is only supported for read commands" )
+	default:
 		http.Handle( path, writeHandler( h ) )
 	}
 
@@ -148,7 +211,7 @@ func setHandlers( httpdConf config, sched *scheduler ) error {
 	}
 
 	// Set handler for each location
-	for key, cc := range sched.commandMap {
+	for key, cc := range sched.commandConfigs() {
 		locConf, err := locsConf.GetSubConfig( key )
 		if err != nil {
 			return fmt.Errorf( "Unable to find location for command '%v': %v", key, err )
@@ -221,6 +284,11 @@ func runHttpd( conf config, sched *scheduler ) error {
 	if err = setHandlers( httpdConf, sched ); err != nil {
 		return fmt.Errorf( "Unable to set httpd handlers: %v", err )
 	}
+	http.HandleFunc( "/healthz", healthzHandler( sched ) )
+	http.HandleFunc( "/readyz", readyzHandler( sched ) )
+	http.HandleFunc( "/healthz/stream", healthStreamHandler( sched ) )
+	http.Handle( "/commands", sched.registry )
+	http.Handle( "/commands/", sched.registry )
 
 	// Run the server
 	return serveHttp( httpdConf )
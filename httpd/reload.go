@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import(
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+)
+
+// dVaultPollInterval is how often WatchVaultConfig checks whether the
+// configuration at the watched Vault path has changed.
+const dVaultPollInterval = 30 * time.Second
+
+// reloadCommands reconciles sched's commandMap with the commands
+// described by newCommandsConf: removed commands are torn down, new or
+// changed commands are (re-)added. Changing a command's own schedule or
+// register parameters this way takes effect without a process restart.
+// It does not itself touch the underlying modbus connection; a change
+// to the bus address or serial line credentials is handled separately,
+// by scheduler.reconnect, which ConfigWatcher.reload calls before
+// reloadCommands when it detects the connection configuration changed.
+func ( s *scheduler ) reloadCommands( newCommandsConf config ) error {
+	for _, name := range s.commandNames() {
+		commandConf, err := newCommandsConf.GetSubConfig( name )
+		s.commandMutex.Lock()
+		unchanged := err == nil && reflect.DeepEqual( commandConf, s.commandConfOf[name] )
+		s.commandMutex.Unlock()
+		if !unchanged {
+			if err := s.RemoveCommand( name ); err != nil {
+				return fmt.Errorf( "Unable to remove stale command '%s': %v", name, err )
+			}
+		}
+	}
+	for name := range newCommandsConf {
+		if s.hasCommand( name ) {
+			continue
+		}
+		commandConf, err := newCommandsConf.GetSubConfig( name )
+		if err != nil {
+			return fmt.Errorf( "Command configuration error for '%s': %v", name, err )
+		}
+		if err := s.AddCommand( name, commandConf ); err != nil {
+			return fmt.Errorf( "Unable to add reloaded command '%s': %v", name, err )
+		}
+	}
+
+	return nil
+}
+
+// WatchVaultConfig polls vc for changes to the KV version of the
+// secret at path every dVaultPollInterval, and calls reload with the
+// newly read commands configuration whenever the version changes. It
+// runs until stopChan is closed.
+func WatchVaultConfig( vc *vaultConfig, path string, stopChan <-chan struct{}, reload func( config ) error ) {
+	lastVersion, err := vc.version( path )
+	if err != nil {
+		log.Printf( "Unable to determine initial Vault config version: %v", err )
+	}
+	ticker := time.NewTicker( dVaultPollInterval )
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+
+		case <-ticker.C:
+			version, err := vc.version( path )
+			if err != nil {
+				log.Printf( "Unable to check Vault config version: %v", err )
+				continue
+			}
+			if version == lastVersion {
+				continue
+			}
+			conf, err := vc.Read( path )
+			if err != nil {
+				log.Printf( "Unable to read updated Vault config: %v", err )
+				continue
+			}
+			commandsConf, err := conf.GetSubConfig( kScheduler )
+			if err != nil {
+				log.Printf( "Updated Vault config has no scheduler section: %v", err )
+				continue
+			}
+			commandsConf, err = commandsConf.GetSubConfig( kCommands )
+			if err != nil {
+				log.Printf( "Updated Vault config has no commands section: %v", err )
+				continue
+			}
+			if err := reload( commandsConf ); err != nil {
+				log.Printf( "Unable to apply updated Vault config: %v", err )
+				continue
+			}
+			lastVersion = version
+			log.Printf( "Applied updated Vault config, version %d", version )
+		}
+	}
+}
@@ -24,9 +24,13 @@ package main
 
 import(
 	"fmt"
+	"github.com/TheCount/goodbus/decode"
+	"github.com/TheCount/goodbus/mbhttp"
 	"github.com/TheCount/goodbus/mbsched"
 	"github.com/TheCount/goodbus/sched"
+	"github.com/TheCount/goodbus/sink"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -35,19 +39,35 @@ const (
 	kAddress = "address"
 	kBaudRate = "baudrate"
 	kBufferSize = "buffersize"
+	kBufferPath = "bufferpath"
 	kCommands = "commands"
+	kCron = "cron"
 	kDataBits = "databits"
+	kDSN = "dsn"
+	kFlushInterval = "flushinterval"
+	kFlushRows = "flushrows"
 	kIdle = "onlyonidle"
+	kInterval = "interval"
 	kMaxWait = "maxwait"
 	kMinWait = "minwait"
 	kParity = "parity"
+	kPoolSize = "poolsize"
+	kFixedOffset = "fixedoffset"
 	kQuantity = "quantity"
 	kRepeat = "repeat"
+	kRetry = "retry"
+	kRetryInitialInterval = "initialinterval"
+	kRetryMaxInterval = "maxinterval"
+	kRetryMaxElapsedTime = "maxelapsedtime"
+	kRetryMultiplier = "multiplier"
+	kRetryMaxRetries = "maxretries"
 	kScheduler = "scheduler"
+	kSink = "sink"
 	kSlaveId = "slaveid"
 	kStopBits = "stopbits"
 	kTimeout = "timeout"
 	kType = "type"
+	kWatchConfig = "watchconfig"
 )
 
 // configuration values
@@ -59,10 +79,19 @@ const (
 	vModbusAscii = "ModbusASCII"
 	vModbusRTU = "ModbusRTU"
 	vModbusTCP = "ModbusTCP"
+	vDefaultPoolSize = 1
+	vReadCoils = "readCoils"
+	vReadDiscreteInputs = "readDiscreteInputs"
 	vReadHoldingRegisters = "readHoldingRegisters"
 	vReadInputRegisters = "readInputRegisters"
 	vSchedulerTimeout = 5 * time.Second
 	vSchedulerBufsize = 5
+	vErrorWindowDuration = 5 * time.Minute
+	vErrorWindowBacklog = 5
+	vDefaultRetryMultiplier = 2.0
+	vDefaultRetryMaxRetries = 0
+	vWriteSingleCoil = "writeSingleCoil"
+	vWriteMultipleCoils = "writeMultipleCoils"
 	vWriteSingleRegister = "writeSingleRegister"
 	vWriteMultipleRegisters = "writeMultipleRegisters"
 )
@@ -74,12 +103,62 @@ const(
 	MaxModbusQuantity = 255
 )
 
+// checkQuantityBound checks a command's quantity against
+// MaxModbusQuantity. It is shared by fillCommand, which builds and
+// starts a command's live schedule, and validateCommandsConf, which
+// validates a reloaded configuration before anything is touched, so
+// the bound enforced at startup/AddCommand time and the bound enforced
+// during validation can't drift apart. A quantity of zero (returned by
+// GetUInt16 when kQuantity is absent or malformed) always passes; each
+// command type that actually requires a quantity checks for its
+// presence separately.
+func checkQuantityBound( quantity uint16, name string ) error {
+	if quantity > MaxModbusQuantity {
+		return fmt.Errorf( "Register quantity %v out of bounds for command '%s'", quantity, name )
+	}
+
+	return nil
+}
+
 type commandConfig struct {
 	scratchpad *Scratchpad
 
 	// Command launcher for one-shot commands.
 	// Nil for repeated commands.
 	launcher func() error
+
+	// decoder, if non-nil, is installed on scratchpad so every update
+	// also populates a named, typed view of the command's data. Nil if
+	// the command declares no "values" schema.
+	decoder *decode.Decoder
+
+	// valuesConf is this command's raw "values" configuration, used to
+	// build the value object handed to sink on every update. Nil if the
+	// command declares no "values" schema.
+	valuesConf config
+
+	// sink, if non-nil, receives a value object built from every update,
+	// in addition to the update going to scratchpad.
+	sink sink.Sink
+}
+
+// recordResult updates cc's scratchpad with result and, if cc has a
+// sink configured, also builds a value object from result and writes
+// it to the sink, so the command's history reaches the sink the same
+// way it reaches Get/Subscribe.
+func ( cc *commandConfig ) recordResult( result []byte ) error {
+	if err := cc.scratchpad.Update( result ); err != nil {
+		return fmt.Errorf( "Unable to update scratchpad: %v", err )
+	}
+	if cc.sink == nil {
+		return nil
+	}
+	obj, err := buildObject( time.Now(), result, cc.valuesConf )
+	if err != nil {
+		return fmt.Errorf( "Unable to build value object for sink: %v", err )
+	}
+
+	return cc.sink.Write( obj )
 }
 
 // IsReadCommand returns true if and only if the underlying modbus command
@@ -93,8 +172,35 @@ func ( c *commandConfig ) IsReadCommand() bool {
 type scheduler struct {
 	mbsched.Scheduler
 
+	// schedMutex serializes reconnect calls against each other, so the
+	// embedded Scheduler is only ever stopped, rebuilt and started by
+	// one goroutine at a time.
+	schedMutex sync.Mutex
+
+	// commandMutex protects commandMap and commandConfOf against
+	// concurrent access: besides the startup call from fillCommands,
+	// AddCommand/RemoveCommand are also called from WatchCommands,
+	// ConfigWatcher.reload and WatchVaultConfig, each running on its own
+	// background goroutine, and the map is also read by the health and
+	// HTTP handler setup code.
+	commandMutex sync.Mutex
+
 	// commandMap maps modbus command names to command configurations
 	commandMap map[string]*commandConfig
+
+	// commandConfOf remembers the raw configuration each command in
+	// commandMap was last built from, so reloadCommands can tell
+	// whether a command's configuration actually changed.
+	commandConfOf map[string]config
+
+	// registry serves this scheduler's commands over the /commands
+	// HTTP endpoints (see mbhttp).
+	registry *mbhttp.Registry
+
+	// sink, if non-nil, is bound to each command's name (via ForSource)
+	// and installed on its commandConfig, so every update is also
+	// written there. Nil if no top-level "sink" configuration is set.
+	sink *sink.PostgresSink
 }
 
 // getAddrTimeoutBufsizeConf gets configuration common to
@@ -116,6 +222,20 @@ func getAddrTimeoutBufsizeConf( conf config ) ( string, time.Duration, int, erro
 	return addr, timeout, bufsize, nil
 }
 
+// getPoolSizeConf gets the configured TCP connection pool size,
+// defaulting to vDefaultPoolSize.
+func getPoolSizeConf( conf config ) ( int, error ) {
+	poolSize, err := conf.GetIntOrDefault( kPoolSize, vDefaultPoolSize )
+	if err != nil {
+		return 0, fmt.Errorf( "Unable to read pool size: %v", err )
+	}
+	if poolSize <= 0 {
+		return 0, fmt.Errorf( "Pool size must be positive, got %d", poolSize )
+	}
+
+	return poolSize, nil
+}
+
 // getSerialConf gets configuration for the
 // serial modbus types.
 func getSerialConf( conf config ) ( int, int, string, int, error ) {
@@ -139,39 +259,44 @@ func getSerialConf( conf config ) ( int, int, string, int, error ) {
 	return baudRate, dataBits, parity, stopBits, nil
 }
 
-// watchSchedulerErrors logs scheduler errors
+// watchSchedulerErrors logs scheduler errors, records them in window,
 // and exits the program if too many errors occur in too short a time.
-func watchSchedulerErrors( errchan <-chan error ) {
-	const timeout = 5 * time.Minute
+func watchSchedulerErrors( errchan <-chan error, window *mbhttp.ErrorWindow ) {
 	const maxErrCount = 5
-	lastCountReset := time.Now()
-	errCount := 0
 	for err := range errchan {
 		log.Printf( "Scheduler error: %v", err )
-		now := time.Now()
-		if now.Sub( lastCountReset ) > timeout {
-			errCount = 1
-			lastCountReset = now
-		} else {
-			errCount++
-		}
-		if errCount > maxErrCount {
+		if window.Record( err ) > maxErrCount {
 			log.Fatal( "Too many scheduler errors in too short a time" )
 		}
 	}
 }
 
-// startEmptyScheduler starts an empty scheduler
-// according to a configuration.
-func startEmptyScheduler( conf config ) ( *scheduler, error ) {
-	// get scheduler type
+// watchRetryReports logs RetryReports from retryChan. Reports are
+// purely informational: a command which exhausts its retries also
+// delivers its terminal error over the scheduler's regular error
+// channel, which watchSchedulerErrors already records in window, so
+// watchRetryReports must not record it again.
+func watchRetryReports( retryChan <-chan mbsched.RetryReport ) {
+	for report := range retryChan {
+		if report.Err == nil {
+			log.Printf( "Command '%s' succeeded after %d attempts", report.Name, report.Attempts )
+		} else {
+			log.Printf( "Command '%s' exhausted retries after %d attempts: %v", report.Name, report.Attempts, report.Err )
+		}
+	}
+}
+
+// newMbschedScheduler builds the unstarted mbsched.Scheduler described
+// by conf's kType/address/serial/pool settings, without touching any
+// httpd-level state (commandMap, registry, sink). It is the connection
+// construction logic shared by startEmptyScheduler, which builds it
+// once at startup, and reconnect, which rebuilds it when the bus
+// connection configuration changes underneath a running scheduler.
+func newMbschedScheduler( conf config ) ( *mbsched.Scheduler, error ) {
 	schedType, err := conf.GetString( kType )
 	if err != nil {
 		return nil, fmt.Errorf( "Scheduler type not found: %v", kType )
 	}
-
-	// configure scheduler according to type
-	var result *scheduler
 	addr, timeout, bufsize, err := getAddrTimeoutBufsizeConf( conf )
 	if err != nil {
 		return nil, err
@@ -182,35 +307,95 @@ func startEmptyScheduler( conf config ) ( *scheduler, error ) {
 		if serialErr != nil {
 			return nil, serialErr
 		}
-		result = &scheduler{
-			Scheduler: *mbsched.NewModbusAsciiScheduler( bufsize, addr, baudRate, dataBits, parity, stopBits, timeout ),
-		}
+
+		return mbsched.NewModbusAsciiScheduler( bufsize, addr, baudRate, dataBits, parity, stopBits, timeout ), nil
 	case vModbusRTU:
 		if serialErr != nil {
 			return nil, serialErr
 		}
-		result = &scheduler{
-			Scheduler: *mbsched.NewModbusRtuScheduler( bufsize, addr, baudRate, dataBits, parity, stopBits, timeout ),
-		}
+
+		return mbsched.NewModbusRtuScheduler( bufsize, addr, baudRate, dataBits, parity, stopBits, timeout ), nil
 	case vModbusTCP:
-		result = &scheduler{
-			Scheduler: *mbsched.NewModbusTcpScheduler( bufsize, addr, timeout ),
+		poolSize, err := getPoolSizeConf( conf )
+		if err != nil {
+			return nil, err
 		}
-	default:
-		return nil, fmt.Errorf( "Unknown scheduler type: %s", schedType )
+
+		return mbsched.NewModbusTcpScheduler( bufsize, addr, poolSize, timeout ), nil
 	}
 
+	return nil, fmt.Errorf( "Unknown scheduler type: %s", schedType )
+}
+
+// startEmptyScheduler starts an empty scheduler
+// according to a configuration.
+func startEmptyScheduler( conf config ) ( *scheduler, error ) {
+	mbs, err := newMbschedScheduler( conf )
+	if err != nil {
+		return nil, err
+	}
+	result := &scheduler{ Scheduler: *mbs }
+
 	// Start scheduler
-	errChan, err := result.Start( vErrorBacklog )
+	errChan, retryChan, err := result.Start( vErrorBacklog )
 	if err != nil {
 		return nil, fmt.Errorf( "Error starting scheduler: %v", err )
 	}
-	go watchSchedulerErrors( errChan )
+	errWindow := mbhttp.NewErrorWindow( vErrorWindowDuration, vErrorWindowBacklog )
+	result.registry = mbhttp.NewRegistry( errWindow )
+	go watchSchedulerErrors( errChan, errWindow )
+	go watchRetryReports( retryChan )
 
 	return result, nil
 }
 
-// getScheduleConf obtains the configuration for a schedule.
+// reconnect tears down s's current bus connection and replaces it with
+// one built from schedConf, then re-adds every command s already has
+// configured against the new connection. Unlike reloadCommands, which
+// only reconciles the command set, reconnect is for the bus connection
+// itself (address, serial line parameters, pool size) changing
+// underneath a running scheduler: mbsched.Scheduler's Pool normally
+// can't be swapped out once Start has opened it, so this stops the old
+// embedded mbsched.Scheduler, discards it, and starts a fresh one in
+// its place. schedMutex serializes this against concurrent reconnects;
+// it does not serialize against AddCommand/RemoveCommand, which guard
+// their own state via commandMutex and are safe to race with a
+// reconnect that has not yet reached the re-add step (they simply
+// apply to whichever embedded Scheduler is current at the time).
+func ( s *scheduler ) reconnect( schedConf config ) error {
+	s.schedMutex.Lock()
+	defer s.schedMutex.Unlock()
+
+	mbs, err := newMbschedScheduler( schedConf )
+	if err != nil {
+		return fmt.Errorf( "Unable to build new scheduler connection: %v", err )
+	}
+	if err := s.Scheduler.Stop(); err != nil {
+		return fmt.Errorf( "Unable to stop old scheduler connection: %v", err )
+	}
+	s.Scheduler = *mbs
+	errChan, retryChan, err := s.Scheduler.Start( vErrorBacklog )
+	if err != nil {
+		return fmt.Errorf( "Unable to start new scheduler connection: %v", err )
+	}
+	errWindow := mbhttp.NewErrorWindow( vErrorWindowDuration, vErrorWindowBacklog )
+	go watchSchedulerErrors( errChan, errWindow )
+	go watchRetryReports( retryChan )
+
+	for name, conf := range s.commandConfigsRaw() {
+		if err := s.fillCommand( name, conf ); err != nil {
+			return fmt.Errorf( "Unable to re-add command '%s' to new scheduler connection: %v", name, err )
+		}
+	}
+
+	return nil
+}
+
+// getScheduleConf obtains the configuration for a schedule. A schedule is
+// either calendar-driven, via the mutually exclusive kCron (a cron
+// expression) or kInterval (a fixed duration, sugar for MinWait == MaxWait
+// == the interval) keys, or, absent both, driven by the kMinWait/kMaxWait
+// wait window as before.
 func getScheduleConf( conf config ) ( *sched.Schedule, error ) {
 	repeat, err := conf.GetBoolOrDefault( kRepeat, false )
 	if err != nil {
@@ -220,18 +405,36 @@ func getScheduleConf( conf config ) ( *sched.Schedule, error ) {
 	if err != nil {
 		return nil, fmt.Errorf( "Unable to read idle setting: %v", err )
 	}
-	minWait, err := conf.GetDurationOrDefault( kMinWait, vDefaultMinWait )
+	cronSpec, err := conf.GetStringOrDefault( kCron, "" )
 	if err != nil {
-		return nil, fmt.Errorf( "Unable to read minimum wait duration: %v", err )
+		return nil, fmt.Errorf( "Unable to read cron expression: %v", err )
 	}
-	maxWait, err := conf.GetDurationOrDefault( kMaxWait, vDefaultMaxWait )
+	interval, err := conf.GetDurationOrDefault( kInterval, 0 )
 	if err != nil {
-		return nil, fmt.Errorf( "Unable to read maximum wait duration: %v", err )
+		return nil, fmt.Errorf( "Unable to read interval: %v", err )
+	}
+	if cronSpec != "" && interval != 0 {
+		return nil, fmt.Errorf( "'%s' and '%s' are mutually exclusive in a schedule", kCron, kInterval )
 	}
 
-	result := &sched.Schedule{
-		MinWait: minWait,
-		MaxWait: maxWait,
+	result := &sched.Schedule{}
+	switch {
+	case cronSpec != "":
+		result.CronSpec = cronSpec
+	case interval != 0:
+		result.MinWait = interval
+		result.MaxWait = interval
+	default:
+		minWait, err := conf.GetDurationOrDefault( kMinWait, vDefaultMinWait )
+		if err != nil {
+			return nil, fmt.Errorf( "Unable to read minimum wait duration: %v", err )
+		}
+		maxWait, err := conf.GetDurationOrDefault( kMaxWait, vDefaultMaxWait )
+		if err != nil {
+			return nil, fmt.Errorf( "Unable to read maximum wait duration: %v", err )
+		}
+		result.MinWait = minWait
+		result.MaxWait = maxWait
 	}
 	if repeat {
 		result.Flags |= sched.ScheduleRepeat
@@ -258,9 +461,174 @@ func getCommandAddress( conf config ) ( uint8, uint16, error ) {
 	return slaveId, address, nil
 }
 
-func watchResultChan( scratchpad *Scratchpad, rChan <-chan []byte ) {
+// getRetryPolicyConf obtains a command's in-call retry configuration
+// from its optional "retry" subsection, defaulting to a disabled
+// RetryPolicy (MaxRetries 0) if the subsection is absent.
+func getRetryPolicyConf( conf config ) ( mbsched.RetryPolicy, error ) {
+	retryConf, err := conf.GetSubConfig( kRetry )
+	if err != nil {
+		return mbsched.RetryPolicy{}, nil
+	}
+	initialInterval, err := retryConf.GetDurationOrDefault( kRetryInitialInterval, 0 )
+	if err != nil {
+		return mbsched.RetryPolicy{}, fmt.Errorf( "Unable to read retry initial interval: %v", err )
+	}
+	maxInterval, err := retryConf.GetDurationOrDefault( kRetryMaxInterval, 0 )
+	if err != nil {
+		return mbsched.RetryPolicy{}, fmt.Errorf( "Unable to read retry maximum interval: %v", err )
+	}
+	maxElapsedTime, err := retryConf.GetDurationOrDefault( kRetryMaxElapsedTime, 0 )
+	if err != nil {
+		return mbsched.RetryPolicy{}, fmt.Errorf( "Unable to read retry maximum elapsed time: %v", err )
+	}
+	multiplier, err := retryConf.GetFloatOrDefault( kRetryMultiplier, vDefaultRetryMultiplier )
+	if err != nil {
+		return mbsched.RetryPolicy{}, fmt.Errorf( "Unable to read retry multiplier: %v", err )
+	}
+	maxRetries, err := retryConf.GetIntOrDefault( kRetryMaxRetries, vDefaultRetryMaxRetries )
+	if err != nil {
+		return mbsched.RetryPolicy{}, fmt.Errorf( "Unable to read retry max retries: %v", err )
+	}
+
+	return mbsched.RetryPolicy{
+		InitialInterval: initialInterval,
+		MaxInterval: maxInterval,
+		MaxElapsedTime: maxElapsedTime,
+		Multiplier: multiplier,
+		MaxRetries: maxRetries,
+	}, nil
+}
+
+// getValuesConf returns a command's "values" subconfiguration, or nil
+// if it declares none; a command need not have one unless it wants
+// typed decoding, a sink, or both.
+func getValuesConf( conf config ) config {
+	valuesConf, err := conf.GetSubConfig( kValues )
+	if err != nil {
+		return nil
+	}
+
+	return valuesConf
+}
+
+// getDecoderConf builds an optional decode.Decoder from a command's
+// "values" subsection, if present. The subsection uses the same key
+// names httpd's builder.go JSON encoding reads (offset, type, length,
+// bitmap, scaler), but its type values are decode.Type names (u16, i16,
+// u32_be, ...), of which only "bitfield" is also a builder.go type, so a
+// location's "values" for the HTTP JSON view is a separate declaration.
+// A command with no "values" subsection gets
+// a nil decoder. A bitfield value's "bitmap" list is read via
+// config.GetList.
+func getDecoderConf( valuesConf config ) ( *decode.Decoder, error ) {
+	if valuesConf == nil {
+		return nil, nil
+	}
+	fields := make( []decode.Field, 0, len( valuesConf ) )
+	for name, _ := range valuesConf {
+		fieldConf, err := valuesConf.GetSubConfig( name )
+		if err != nil {
+			return nil, fmt.Errorf( "Unable to get value configuration '%s': %v", name, err )
+		}
+		offset, err := fieldConf.GetUInt( kOffset )
+		if err != nil {
+			return nil, fmt.Errorf( "Unable to read offset for value '%s': %v", name, err )
+		}
+		typeString, err := fieldConf.GetString( kType )
+		if err != nil {
+			return nil, fmt.Errorf( "Unable to read type for value '%s': %v", name, err )
+		}
+		field := decode.Field{
+			Name: name,
+			Offset: offset,
+			Type: decode.Type( typeString ),
+		}
+		switch field.Type {
+		case decode.TypeBitfield, decode.TypeASCII:
+			length, err := fieldConf.GetUInt( kLength )
+			if err != nil {
+				return nil, fmt.Errorf( "Unable to read length for value '%s': %v", name, err )
+			}
+			field.Length = length
+			if field.Type == decode.TypeBitfield {
+				list, err := fieldConf.GetList( kBitmap )
+				if err != nil {
+					return nil, fmt.Errorf( "Unable to read bitmap for value '%s': %v", name, err )
+				}
+				bits := make( []string, len( list ) )
+				for i, item := range list {
+					if item == nil {
+						continue
+					}
+					bitName, ok := item.( string )
+					if !ok {
+						return nil, fmt.Errorf( "Bitmap entry for value '%s' must be a string: %v", name, item )
+					}
+					bits[i] = bitName
+				}
+				field.Bits = bits
+			}
+		case decode.TypeFixed:
+			scale, err := fieldConf.GetFloatOrDefault( kScaler, 1.0 )
+			if err != nil {
+				return nil, fmt.Errorf( "Unable to read scaler for value '%s': %v", name, err )
+			}
+			fixedOffset, err := fieldConf.GetFloatOrDefault( kFixedOffset, 0.0 )
+			if err != nil {
+				return nil, fmt.Errorf( "Unable to read fixed offset for value '%s': %v", name, err )
+			}
+			field.Scale = scale
+			field.FixedOffset = fixedOffset
+		}
+		fields = append( fields, field )
+	}
+
+	decoder, err := decode.NewDecoder( fields )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to build decoder: %v", err )
+	}
+
+	return decoder, nil
+}
+
+// getSinkConf builds an optional sink.PostgresSink from the top-level
+// "sink" configuration, if present. A missing "sink" section means no
+// sink is configured, in which case commands are scratchpad-only as
+// before.
+func getSinkConf( conf config ) ( *sink.PostgresSink, error ) {
+	sinkConf, err := conf.GetSubConfig( kSink )
+	if err != nil {
+		return nil, nil
+	}
+	dsn, err := sinkConf.GetString( kDSN )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to read sink DSN: %v", err )
+	}
+	flushInterval, err := sinkConf.GetDurationOrDefault( kFlushInterval, 0 )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to read sink flush interval: %v", err )
+	}
+	flushRows, err := sinkConf.GetIntOrDefault( kFlushRows, 0 )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to read sink flush row count: %v", err )
+	}
+	bufferPath, err := sinkConf.GetStringOrDefault( kBufferPath, "" )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to read sink disk buffer path: %v", err )
+	}
+	result, err := sink.NewPostgresSink( dsn, flushInterval, flushRows, bufferPath )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to start postgres sink: %v", err )
+	}
+
+	return result, nil
+}
+
+func watchResultChan( name string, cc *commandConfig, rChan <-chan []byte ) {
 	for result := range rChan {
-		scratchpad.Update( result )
+		if err := cc.recordResult( result ); err != nil {
+			log.Printf( "Unable to record result for command '%s': %v", name, err )
+		}
 	}
 }
 
@@ -280,32 +648,142 @@ func ( s *scheduler ) fillCommand( name string, conf config ) error {
 		return fmt.Errorf( "Unable to get type of command '%s': %v", name, err )
 	}
 	quantity, qErr := conf.GetUInt16( kQuantity )
-	if ( quantity > MaxModbusQuantity ) {
-		return fmt.Errorf( "Register quantity %v out of bounds for command '%s'", quantity, name )
+	if err := checkQuantityBound( quantity, name ); err != nil {
+		return err
+	}
+	retry, err := getRetryPolicyConf( conf )
+	if err != nil {
+		return fmt.Errorf( "Unable to get retry configuration for command '%s': %v", name, err )
+	}
+	valuesConf := getValuesConf( conf )
+	decoder, err := getDecoderConf( valuesConf )
+	if err != nil {
+		return fmt.Errorf( "Unable to get values configuration for command '%s': %v", name, err )
 	}
 	cc := &commandConfig{
 		scratchpad: NewScratchpad( 2 * int( quantity ) ),
 		launcher: nil,
+		decoder: decoder,
+		valuesConf: valuesConf,
+	}
+	if s.sink != nil {
+		cc.sink = s.sink.ForSource( name )
 	}
 	switch ( typeString ) {
+	case vReadCoils, vReadDiscreteInputs, vWriteMultipleCoils:
+		// Coil/discrete input data is packed one bit per quantity,
+		// unlike registers, which are two bytes each.
+		cc.scratchpad = NewBitScratchpad( int( quantity ) ).Scratchpad
+	case vWriteSingleCoil:
+		// A single coil value is packed into one byte: zero for off,
+		// non-zero for on.
+		cc.scratchpad = NewScratchpad( 1 )
+	}
+	cc.scratchpad.SetDecoder( cc.decoder )
+	switch ( typeString ) {
+	case vReadCoils:
+		if qErr != nil {
+			return fmt.Errorf( "read coils: %v", qErr )
+		}
+		rChan, err := s.AddReadCoils( name, *schedule, vSchedulerBufsize, slaveId, addr, quantity, retry )
+		if err != nil {
+			return fmt.Errorf( "Unable to create read coils schedule: %v", err )
+		}
+		go watchResultChan( name, cc, rChan )
+	case vReadDiscreteInputs:
+		if qErr != nil {
+			return fmt.Errorf( "read discrete inputs: %v", qErr )
+		}
+		rChan, err := s.AddReadDiscreteInputs( name, *schedule, vSchedulerBufsize, slaveId, addr, quantity, retry )
+		if err != nil {
+			return fmt.Errorf( "Unable to create read discrete inputs schedule: %v", err )
+		}
+		go watchResultChan( name, cc, rChan )
+	case vWriteSingleCoil:
+		if schedule.Flags & sched.ScheduleRepeat != 0 {
+			return fmt.Errorf( "Repeat '%s' not supported for write single coil", name )
+		}
+		cc.launcher = func() error {
+			_, data := cc.scratchpad.Get()
+			if data == nil {
+				log.Panicf( "Internal error: data for '%s' not set", name )
+			}
+			if len( data ) < 1 {
+				log.Panicf( "Internal error: data for '%s' too short", name )
+			}
+			value := uint16( 0x0000 )
+			if data[0] != 0 {
+				value = 0xFF00
+			}
+			rChan, err := s.AddWriteSingleCoil( name, *schedule, vSchedulerBufsize, slaveId, addr, value, retry )
+			if err != nil {
+				return fmt.Errorf( "Unable to add write single coil command: %v", err )
+			}
+			result, ok := <-rChan
+			if !ok {
+				return fmt.Errorf( "No data from result channel for '%s'", name )
+			}
+			if err := cc.recordResult( result ); err != nil {
+				return fmt.Errorf( "Unable to record result for '%s': %v", name, err )
+			}
+			_, ok = <-rChan
+			if ok {
+				return fmt.Errorf( "Result channel did not close for '%s'", name )
+			}
+
+			return nil
+		}
+	case vWriteMultipleCoils:
+		if qErr != nil {
+			return fmt.Errorf( "write multiple coils: %v", qErr )
+		}
+		if schedule.Flags & sched.ScheduleRepeat != 0 {
+			return fmt.Errorf( "Repeat '%s' not supported for write multiple coils", name )
+		}
+		cc.launcher = func() error {
+			_, data := cc.scratchpad.Get()
+			if data == nil {
+				log.Panicf( "Internal error: data for '%s' not set", name )
+			}
+			if len( data ) < ( int( quantity ) + 7 ) / 8 {
+				log.Panicf( "Internal error: data for '%s' too short", name )
+			}
+			rChan, err := s.AddWriteMultipleCoils( name, *schedule, vSchedulerBufsize, slaveId, addr, quantity, data, retry )
+			if err != nil {
+				return fmt.Errorf( "Unable to add write multiple coils command: %v", err )
+			}
+			result, ok := <-rChan
+			if !ok {
+				return fmt.Errorf( "No data from result channel for '%s'", name )
+			}
+			if err := cc.recordResult( result ); err != nil {
+				return fmt.Errorf( "Unable to record result for '%s': %v", name, err )
+			}
+			_, ok = <-rChan
+			if ok {
+				return fmt.Errorf( "Result channel did not close for '%s'", name )
+			}
+
+			return nil
+		}
 	case vReadHoldingRegisters:
 		if qErr != nil {
 			return fmt.Errorf( "read holding registers: %v", qErr )
 		}
-		rChan, err := s.AddReadHoldingRegisters( name, *schedule, vSchedulerBufsize, slaveId, addr, quantity )
+		rChan, err := s.AddReadHoldingRegisters( name, *schedule, vSchedulerBufsize, slaveId, addr, quantity, retry )
 		if err != nil {
 			return fmt.Errorf( "Unable to create read holding registers schedule: %v", err )
 		}
-		go watchResultChan( cc.scratchpad, rChan )
+		go watchResultChan( name, cc, rChan )
 	case vReadInputRegisters:
 		if qErr != nil {
 			return fmt.Errorf( "read input registers: %v", qErr )
 		}
-		rChan, err := s.AddReadInputRegisters( name, *schedule, vSchedulerBufsize, slaveId, addr, quantity )
+		rChan, err := s.AddReadInputRegisters( name, *schedule, vSchedulerBufsize, slaveId, addr, quantity, retry )
 		if err != nil {
 			return fmt.Errorf( "Unable to create read input registers schedule: %v", err )
 		}
-		go watchResultChan( cc.scratchpad, rChan )
+		go watchResultChan( name, cc, rChan )
 	case vWriteSingleRegister:
 		if schedule.Flags & sched.ScheduleRepeat != 0 {
 			return fmt.Errorf( "Repeat '%s' not supported for write single register", name )
@@ -318,7 +796,7 @@ func ( s *scheduler ) fillCommand( name string, conf config ) error {
 			if len( data ) < 2 {
 				log.Panicf( "Internal error: data for '%s' too short", name )
 			}
-			rChan, err := s.AddWriteSingleRegister( name, *schedule, vSchedulerBufsize, slaveId, addr, ( uint16( data[0] ) << 8 ) | uint16( data[1] ) )
+			rChan, err := s.AddWriteSingleRegister( name, *schedule, vSchedulerBufsize, slaveId, addr, ( uint16( data[0] ) << 8 ) | uint16( data[1] ), retry )
 			if err != nil {
 				return fmt.Errorf( "Unable to add write single register command: %v", err )
 			}
@@ -326,7 +804,9 @@ func ( s *scheduler ) fillCommand( name string, conf config ) error {
 			if !ok {
 				return fmt.Errorf( "No data from result channel for '%s'", name )
 			}
-			cc.scratchpad.Update( result )
+			if err := cc.recordResult( result ); err != nil {
+				return fmt.Errorf( "Unable to record result for '%s': %v", name, err )
+			}
 			_, ok = <-rChan
 			if ok {
 				return fmt.Errorf( "Result channel did not close for '%s'", name )
@@ -349,7 +829,7 @@ func ( s *scheduler ) fillCommand( name string, conf config ) error {
 			if quantity > 256 || len( data ) < 2 * int( quantity ) {
 				log.Panicf( "Internal error: data for '%s' too short", name )
 			}
-			rChan, err := s.AddWriteMultipleRegisters( name, *schedule, vSchedulerBufsize, slaveId, addr, quantity, data )
+			rChan, err := s.AddWriteMultipleRegisters( name, *schedule, vSchedulerBufsize, slaveId, addr, quantity, data, retry )
 			if err != nil {
 				return fmt.Errorf( "Unable to add write multiple registers command: %v", err )
 			}
@@ -357,7 +837,9 @@ func ( s *scheduler ) fillCommand( name string, conf config ) error {
 			if !ok {
 				return fmt.Errorf( "No data from result channel for '%s'", name )
 			}
-			cc.scratchpad.Update( result )
+			if err := cc.recordResult( result ); err != nil {
+				return fmt.Errorf( "Unable to record result for '%s': %v", name, err )
+			}
 			_, ok = <-rChan
 			if ok {
 				return fmt.Errorf( "Result channel did not close for '%s'", name )
@@ -368,7 +850,31 @@ func ( s *scheduler ) fillCommand( name string, conf config ) error {
 	default:
 		return fmt.Errorf( "Modbus command type '%s' not supported", typeString )
 	}
+	s.commandMutex.Lock()
 	s.commandMap[name] = cc
+	s.commandMutex.Unlock()
+	s.registry.Register( &mbhttp.Command{
+		Name: name,
+		IsReadCommand: cc.IsReadCommand(),
+		Get: func() mbhttp.Sample {
+			t, data := cc.scratchpad.Get()
+
+			return mbhttp.Sample{ Time: t, Data: data }
+		},
+		Subscribe: func() ( <-chan mbhttp.Sample, func() ) {
+			updates, cancel := cc.scratchpad.Subscribe()
+			out := make( chan mbhttp.Sample )
+			go func() {
+				defer close( out )
+				for update := range updates {
+					out <- mbhttp.Sample{ Time: update.Time, Data: update.Data }
+				}
+			}()
+
+			return out, cancel
+		},
+		Launch: cc.launcher,
+	} )
 
 	return nil
 }
@@ -376,13 +882,16 @@ func ( s *scheduler ) fillCommand( name string, conf config ) error {
 // fillCommands fills in the configured commands
 // for the scheduler.
 func ( s *scheduler ) fillCommands( conf config ) error {
+	s.commandMutex.Lock()
 	s.commandMap = make( map[string]*commandConfig )
+	s.commandConfOf = make( map[string]config )
+	s.commandMutex.Unlock()
 	for name, _ := range conf {
 		commandConf, err := conf.GetSubConfig( name )
 		if err != nil {
 			return fmt.Errorf( "Command configuration error: %v", err )
 		}
-		if err = s.fillCommand( name, commandConf ); err != nil {
+		if err := s.AddCommand( name, commandConf ); err != nil {
 			return err
 		}
 	}
@@ -390,6 +899,88 @@ func ( s *scheduler ) fillCommands( conf config ) error {
 	return nil
 }
 
+// hasCommand reports whether name is currently a configured command.
+func ( s *scheduler ) hasCommand( name string ) bool {
+	s.commandMutex.Lock()
+	defer s.commandMutex.Unlock()
+	_, ok := s.commandMap[name]
+
+	return ok
+}
+
+// commandConfigs returns a shallow copy of the currently configured
+// commands, safe to range over without holding commandMutex.
+func ( s *scheduler ) commandConfigs() map[string]*commandConfig {
+	s.commandMutex.Lock()
+	defer s.commandMutex.Unlock()
+	result := make( map[string]*commandConfig, len( s.commandMap ) )
+	for name, cc := range s.commandMap {
+		result[name] = cc
+	}
+
+	return result
+}
+
+// commandNames returns a snapshot of the currently configured command
+// names, safe to range over without holding commandMutex.
+func ( s *scheduler ) commandNames() []string {
+	s.commandMutex.Lock()
+	defer s.commandMutex.Unlock()
+	names := make( []string, 0, len( s.commandMap ) )
+	for name := range s.commandMap {
+		names = append( names, name )
+	}
+
+	return names
+}
+
+// commandConfigsRaw returns a shallow copy of the raw per-command
+// configuration every currently configured command was last built
+// from, safe to range over without holding commandMutex. reconnect
+// uses this to re-add every command to a freshly rebuilt connection.
+func ( s *scheduler ) commandConfigsRaw() map[string]config {
+	s.commandMutex.Lock()
+	defer s.commandMutex.Unlock()
+	result := make( map[string]config, len( s.commandConfOf ) )
+	for name, conf := range s.commandConfOf {
+		result[name] = conf
+	}
+
+	return result
+}
+
+// AddCommand adds one named command to a running scheduler, using the
+// same per-command configuration format fillCommands reads statically
+// at startup. It is exposed so a configwatch.Source (see
+// WatchCommands) can add commands discovered at runtime.
+func ( s *scheduler ) AddCommand( name string, conf config ) error {
+	if err := s.fillCommand( name, conf ); err != nil {
+		return err
+	}
+	s.commandMutex.Lock()
+	s.commandConfOf[name] = conf
+	s.commandMutex.Unlock()
+
+	return nil
+}
+
+// RemoveCommand tears down one named command: its mbsched schedule is
+// removed, which stops further executions, and the command is
+// forgotten so it can be added again later (possibly with a different
+// configuration).
+func ( s *scheduler ) RemoveCommand( name string ) error {
+	if err := s.RemoveSchedule( name ); err != nil {
+		return err
+	}
+	s.commandMutex.Lock()
+	delete( s.commandMap, name )
+	delete( s.commandConfOf, name )
+	s.commandMutex.Unlock()
+	s.registry.Unregister( name )
+
+	return nil
+}
+
 // startScheduler starts a scheduler
 // according to a configuration.
 func startScheduler( conf config ) ( *scheduler, error ) {
@@ -404,6 +995,11 @@ func startScheduler( conf config ) ( *scheduler, error ) {
 	if err != nil {
 		return nil, fmt.Errorf( "Unable to start empty scheduler: %v", err )
 	}
+	valueSink, err := getSinkConf( conf )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to get sink configuration: %v", err )
+	}
+	result.sink = valueSink
 	commandConf, err := conf.GetSubConfig( kCommands )
 	if err != nil {
 		return nil, fmt.Errorf( "Unable to get commands configuration in scheduler configuration '%s': %v", kScheduler, err )
@@ -411,6 +1007,18 @@ func startScheduler( conf config ) ( *scheduler, error ) {
 	if err = result.fillCommands( commandConf ); err != nil {
 		return nil, fmt.Errorf( "Unable to fill scheduler with commands: %v", err )
 	}
+	if watchConf, err := schedConf.GetSubConfig( kConfigWatch ); err == nil {
+		if err := startConfigWatch( watchConf, result ); err != nil {
+			return nil, fmt.Errorf( "Unable to start configuration watch: %v", err )
+		}
+	}
+	watchConfigFile, err := schedConf.GetBoolOrDefault( kWatchConfig, false )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to get configuration file watch flag: %v", err )
+	}
+	if watchConfigFile {
+		NewConfigWatcher( result, schedConf )
+	}
 
 	return result, nil
 }
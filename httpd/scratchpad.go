@@ -23,12 +23,32 @@ SOFTWARE.
 package main
 
 import(
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"github.com/TheCount/goodbus/decode"
+	"go.etcd.io/bbolt"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
-type scratchpadType struct {
+// dSubscriberBacklog is the buffer size of a subscriber channel. A
+// subscriber which falls behind by more than this many updates misses
+// the oldest ones rather than blocking Update.
+const dSubscriberBacklog = 4
+
+// dFlushInterval is how often a persistent Scratchpad coalesces
+// pending samples into its bbolt database, so a high-rate poller does
+// not pay an fsync on every Update.
+const dFlushInterval = time.Second
+
+// ScratchpadSample is a single timestamped value stored in a
+// Scratchpad, either the most recent one returned by Get/Subscribe, or
+// one of the historical samples returned by History/Last for a
+// persistent Scratchpad.
+type ScratchpadSample struct {
 	// Time is the update time.
 	Time time.Time
 
@@ -36,39 +56,408 @@ type scratchpadType struct {
 	Data []byte
 }
 
+// typedSample is the decoded counterpart of a ScratchpadSample, stored
+// alongside it when a Scratchpad has a decoder.
+type typedSample struct {
+	// Time is the update time.
+	Time time.Time
+
+	// Values is the named, typed view of the update, as produced by the
+	// Scratchpad's decoder.
+	Values map[string]interface{}
+}
+
+// Retention bounds how much history a persistent Scratchpad keeps. A
+// zero Count or MaxAge means no bound of that kind; if both are zero,
+// history grows without bound.
+type Retention struct {
+	// Count is the maximum number of samples to retain.
+	Count int
+
+	// MaxAge is the maximum age of a retained sample, relative to the
+	// time of the pruning flush.
+	MaxAge time.Duration
+}
+
 type Scratchpad struct {
 	atomic.Value
 
 	// Size is the immutable size the scratchpad data should have
 	Size int
+
+	// subMutex protects subscribers.
+	subMutex sync.Mutex
+
+	// subscribers holds the channels of current Subscribe callers.
+	subscribers map[chan<- ScratchpadSample]struct{}
+
+	// db is the bbolt database backing durable history, or nil if this
+	// Scratchpad keeps only the latest value.
+	db *bbolt.DB
+
+	// bucket is the name of the db bucket this Scratchpad's samples are
+	// stored under.
+	bucket []byte
+
+	// retention bounds how much history db retains.
+	retention Retention
+
+	// pendingMutex protects pending.
+	pendingMutex sync.Mutex
+
+	// pending holds samples written by Update since the last flush to
+	// db.
+	pending []ScratchpadSample
+
+	// stop, closed by Close, tells the flush goroutine to flush once
+	// more and exit.
+	stop chan struct{}
+
+	// flushDone is closed once the flush goroutine has exited, so
+	// Close can wait for the final flush.
+	flushDone chan struct{}
+
+	// decoder, if non-nil, turns every Update's raw data into a named,
+	// typed map retrievable through GetTyped.
+	decoder *decode.Decoder
+
+	// typed holds the most recent typedSample produced by decoder.
+	typed atomic.Value
+}
+
+// SetDecoder installs decoder as this Scratchpad's decoder, so every
+// subsequent Update also populates the typed map returned by GetTyped. A
+// nil decoder disables typed decoding.
+func ( sp *Scratchpad ) SetDecoder( decoder *decode.Decoder ) {
+	sp.decoder = decoder
 }
 
 // NewScratchpad creates a new scratchpad with the supposed size.
 func NewScratchpad( size int ) *Scratchpad {
 	return &Scratchpad{
 		Size: size,
+		subscribers: make( map[chan<- ScratchpadSample]struct{} ),
+	}
+}
+
+// NewPersistentScratchpad creates a new scratchpad of the supposed
+// size whose history is additionally durably recorded in the bbolt
+// database at path, under a bucket named name, so a command's history
+// survives a process restart for post-mortem analysis. retention
+// bounds how much of that history is kept; samples exceeding it are
+// pruned on the next flush. Writes are coalesced onto a ticker rather
+// than flushed to bolt on every Update, so high-rate polling does not
+// bottleneck on fsync; call Sync to force an immediate flush, and
+// Close to flush and release the database.
+func NewPersistentScratchpad( path, name string, size int, retention Retention ) ( *Scratchpad, error ) {
+	db, err := bbolt.Open( path, 0600, nil )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to open bolt database '%s': %v", path, err )
+	}
+	bucket := []byte( name )
+	err = db.Update( func( tx *bbolt.Tx ) error {
+		_, err := tx.CreateBucketIfNotExists( bucket )
+
+		return err
+	} )
+	if err != nil {
+		db.Close()
+
+		return nil, fmt.Errorf( "Unable to create bucket '%s' in bolt database '%s': %v", name, path, err )
 	}
+
+	sp := NewScratchpad( size )
+	sp.db = db
+	sp.bucket = bucket
+	sp.retention = retention
+	sp.stop = make( chan struct{} )
+	sp.flushDone = make( chan struct{} )
+	go sp.flushLoop( dFlushInterval )
+
+	return sp, nil
 }
 
 func ( sp *Scratchpad ) Update( data []byte ) error {
 	if len( data ) != sp.Size {
 		return fmt.Errorf( "Expected scratchpad data size of %v, got %v", sp.Size, len( data ) )
 	}
-	newvalue := scratchpadType{
-		Time: time.Now(),
+	now := time.Now()
+	newvalue := ScratchpadSample{
+		Time: now,
 		Data: data,
 	}
 	sp.Value.Store( newvalue )
+	sp.broadcast( newvalue )
+	if sp.db != nil {
+		sp.pendingMutex.Lock()
+		sp.pending = append( sp.pending, newvalue )
+		sp.pendingMutex.Unlock()
+	}
+	if sp.decoder != nil {
+		values, err := sp.decoder.Decode( data )
+		if err != nil {
+			return fmt.Errorf( "Unable to decode scratchpad data: %v", err )
+		}
+		sp.typed.Store( typedSample{ Time: now, Values: values } )
+	}
 
 	return nil
 }
 
+// broadcast sends value to all current subscribers, dropping the
+// update for a subscriber whose channel is full rather than blocking.
+func ( sp *Scratchpad ) broadcast( value ScratchpadSample ) {
+	sp.subMutex.Lock()
+	defer sp.subMutex.Unlock()
+	for sub := range sp.subscribers {
+		select {
+		case sub <- value:
+		default:
+		}
+	}
+}
+
+// Subscribe registers a new subscriber for scratchpad updates. The
+// returned channel receives every Update after the call to Subscribe;
+// it is buffered, but an overwhelmed subscriber misses updates rather
+// than stalling Update. The returned cancel function must be called
+// once the subscriber is no longer interested in updates; it closes
+// the channel.
+func ( sp *Scratchpad ) Subscribe() ( <-chan ScratchpadSample, func() ) {
+	ch := make( chan ScratchpadSample, dSubscriberBacklog )
+	sp.subMutex.Lock()
+	sp.subscribers[ch] = struct{}{}
+	sp.subMutex.Unlock()
+	cancel := func() {
+		sp.subMutex.Lock()
+		defer sp.subMutex.Unlock()
+		if _, ok := sp.subscribers[ch]; ok {
+			delete( sp.subscribers, ch )
+			close( ch )
+		}
+	}
+
+	return ch, cancel
+}
+
 func ( sp *Scratchpad ) Get() ( time.Time, []byte ) {
 	value := sp.Value.Load()
 	if value == nil {
 		return time.Time{}, nil
 	}
-	st := value.( scratchpadType )
+	st := value.( ScratchpadSample )
 
 	return st.Time, st.Data
 }
+
+// GetTyped returns the update time and the named, typed values most
+// recently produced by this Scratchpad's decoder. It returns a nil map
+// if no decoder is set, or none has run yet.
+func ( sp *Scratchpad ) GetTyped() ( time.Time, map[string]interface{} ) {
+	value := sp.typed.Load()
+	if value == nil {
+		return time.Time{}, nil
+	}
+	ts := value.( typedSample )
+
+	return ts.Time, ts.Values
+}
+
+// flushLoop periodically flushes pending samples to db until Close
+// closes stop, at which point it flushes one last time and closes
+// flushDone.
+func ( sp *Scratchpad ) flushLoop( interval time.Duration ) {
+	ticker := time.NewTicker( interval )
+	defer ticker.Stop()
+	defer close( sp.flushDone )
+	for {
+		select {
+		case <- ticker.C:
+			sp.flush()
+		case <- sp.stop:
+			sp.flush()
+
+			return
+		}
+	}
+}
+
+// flush writes all samples pending since the last flush into db in a
+// single transaction, then prunes db according to retention.
+func ( sp *Scratchpad ) flush() error {
+	sp.pendingMutex.Lock()
+	batch := sp.pending
+	sp.pending = nil
+	sp.pendingMutex.Unlock()
+	if len( batch ) == 0 {
+		return nil
+	}
+
+	return sp.db.Update( func( tx *bbolt.Tx ) error {
+		b := tx.Bucket( sp.bucket )
+		for _, sample := range batch {
+			if err := b.Put( encodeScratchpadKey( sample.Time ), sample.Data ); err != nil {
+				return fmt.Errorf( "Unable to store scratchpad sample: %v", err )
+			}
+		}
+
+		return sp.prune( b )
+	} )
+}
+
+// prune deletes samples from b which exceed sp.retention.
+func ( sp *Scratchpad ) prune( b *bbolt.Bucket ) error {
+	if sp.retention.MaxAge > 0 {
+		cutoff := encodeScratchpadKey( time.Now().Add( -sp.retention.MaxAge ) )
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare( k, cutoff ) < 0; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return fmt.Errorf( "Unable to prune aged-out scratchpad sample: %v", err )
+			}
+		}
+	}
+	if sp.retention.Count > 0 {
+		excess := b.Stats().KeyN - sp.retention.Count
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && excess > 0; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return fmt.Errorf( "Unable to prune excess scratchpad sample: %v", err )
+			}
+			excess--
+		}
+	}
+
+	return nil
+}
+
+// Sync forces an immediate flush of pending samples to the underlying
+// bbolt database. It is a no-op on a non-persistent Scratchpad.
+func ( sp *Scratchpad ) Sync() error {
+	if sp.db == nil {
+		return nil
+	}
+
+	return sp.flush()
+}
+
+// History returns the persisted samples with timestamps in
+// [from, to], ordered from oldest to newest. It returns an error if
+// this Scratchpad has no persistent backing.
+func ( sp *Scratchpad ) History( from, to time.Time ) ( []ScratchpadSample, error ) {
+	if sp.db == nil {
+		return nil, errors.New( "Scratchpad has no persistent backing" )
+	}
+	fromKey := encodeScratchpadKey( from )
+	toKey := encodeScratchpadKey( to )
+	var result []ScratchpadSample
+	err := sp.db.View( func( tx *bbolt.Tx ) error {
+		c := tx.Bucket( sp.bucket ).Cursor()
+		for k, v := c.Seek( fromKey ); k != nil && bytes.Compare( k, toKey ) <= 0; k, v = c.Next() {
+			result = append( result, decodeScratchpadSample( k, v ) )
+		}
+
+		return nil
+	} )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to read scratchpad history: %v", err )
+	}
+
+	return result, nil
+}
+
+// Last returns the n most recently persisted samples, ordered from
+// oldest to newest. It returns nil if this Scratchpad has no
+// persistent backing.
+func ( sp *Scratchpad ) Last( n int ) []ScratchpadSample {
+	if sp.db == nil || n <= 0 {
+		return nil
+	}
+	var result []ScratchpadSample
+	sp.db.View( func( tx *bbolt.Tx ) error {
+		c := tx.Bucket( sp.bucket ).Cursor()
+		for k, v := c.Last(); k != nil && len( result ) < n; k, v = c.Prev() {
+			result = append( result, decodeScratchpadSample( k, v ) )
+		}
+
+		return nil
+	} )
+	for i, j := 0, len( result ) - 1; i < j; i, j = i + 1, j - 1 {
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return result
+}
+
+// Close stops the flush goroutine, performs a final flush, and closes
+// the underlying bbolt database. It is a no-op on a non-persistent
+// Scratchpad.
+func ( sp *Scratchpad ) Close() error {
+	if sp.db == nil {
+		return nil
+	}
+	close( sp.stop )
+	<- sp.flushDone
+
+	return sp.db.Close()
+}
+
+// encodeScratchpadKey encodes t as a big-endian bbolt key, so keys
+// within a bucket sort chronologically.
+func encodeScratchpadKey( t time.Time ) []byte {
+	key := make( []byte, 8 )
+	binary.BigEndian.PutUint64( key, uint64( t.UnixNano() ) )
+
+	return key
+}
+
+// decodeScratchpadSample reconstructs a ScratchpadSample from a bbolt
+// key/value pair written by flush.
+func decodeScratchpadSample( k, v []byte ) ScratchpadSample {
+	data := make( []byte, len( v ) )
+	copy( data, v )
+
+	return ScratchpadSample{
+		Time: time.Unix( 0, int64( binary.BigEndian.Uint64( k ) ) ),
+		Data: data,
+	}
+}
+
+// BitScratchpad is a bit-level view onto a Scratchpad whose raw bytes
+// are a packed bitmap, as returned by the modbus coil and discrete
+// input commands (one bit per coil/input, packed the same way
+// buildBitfield/serialiseBitfield unpack a bitfield value in
+// builder.go). It lets Go callers read individual coil states instead
+// of having to unpack the raw bytes themselves.
+type BitScratchpad struct {
+	*Scratchpad
+
+	// count is the number of meaningful bits in the scratchpad.
+	count int
+}
+
+// NewBitScratchpad creates a new scratchpad for count coil/discrete
+// input states, sized as the minimum number of bytes required to hold
+// them.
+func NewBitScratchpad( count int ) *BitScratchpad {
+	return &BitScratchpad{
+		Scratchpad: NewScratchpad( ( count + 7 ) / 8 ),
+		count: count,
+	}
+}
+
+// Bits returns the update time and the individual bit states of the
+// most recently stored data, unpacked least-significant-bit first
+// within each byte. It returns a nil slice if no data has been stored
+// yet.
+func ( bsp *BitScratchpad ) Bits() ( time.Time, []bool ) {
+	t, data := bsp.Get()
+	if data == nil {
+		return t, nil
+	}
+	bits := make( []bool, bsp.count )
+	for i := range bits {
+		bits[i] = ( data[i / 8] & ( 1 << ( uint( i ) % 8 ) ) ) != 0
+	}
+
+	return t, bits
+}
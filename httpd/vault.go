@@ -0,0 +1,279 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import(
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// configuration keys for the Vault bootstrap section of the local
+// config file. The local file only needs to describe how to reach
+// Vault; the actual httpd/scheduler/commands configuration is then
+// read from the Vault path given by kVaultPath.
+const(
+	kVault = "vault"
+	kVaultAddr = "address"
+	kVaultToken = "token"
+	kVaultPath = "path"
+)
+
+const(
+	dVaultTimeout = 10 * time.Second
+	dVaultRenewFraction = 2.0 / 3.0
+)
+
+// vaultConfig is a minimal client for the subset of the Vault HTTP API
+// goodbus needs: reading/listing a KV v1 or v2 secret, and renewing its
+// own token. It deliberately avoids a full Vault SDK dependency.
+type vaultConfig struct {
+	addr string
+	token string
+	httpClient *http.Client
+}
+
+// newVaultConfig creates a new Vault client talking to addr,
+// authenticated with token.
+func newVaultConfig( addr string, token string ) *vaultConfig {
+	return &vaultConfig{
+		addr: addr,
+		token: token,
+		httpClient: &http.Client{ Timeout: dVaultTimeout },
+	}
+}
+
+// vaultRequest issues method against the given Vault API path (relative
+// to vc.addr, no leading slash) and decodes the JSON response into out,
+// if out is non-nil.
+func ( vc *vaultConfig ) vaultRequest( method string, path string, out interface{} ) error {
+	req, err := http.NewRequest( method, fmt.Sprintf( "%s/v1/%s", vc.addr, path ), nil )
+	if err != nil {
+		return fmt.Errorf( "Unable to build Vault request for '%s': %v", path, err )
+	}
+	req.Header.Set( "X-Vault-Token", vc.token )
+	resp, err := vc.httpClient.Do( req )
+	if err != nil {
+		return fmt.Errorf( "Vault request '%s' failed: %v", path, err )
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf( "Vault request '%s' returned status %d", path, resp.StatusCode )
+	}
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder( resp.Body ).Decode( out )
+}
+
+// kvVersion probes sys/internal/ui/mounts/<path> to determine whether
+// the secret engine path is mounted under is KV version 1 or 2.
+func ( vc *vaultConfig ) kvVersion( path string ) ( int, error ) {
+	var probe struct {
+		Data struct {
+			Options struct {
+				Version string `json:"version"`
+			} `json:"options"`
+		} `json:"data"`
+	}
+	if err := vc.vaultRequest( "GET", fmt.Sprintf( "sys/internal/ui/mounts/%s", path ), &probe ); err != nil {
+		return 0, fmt.Errorf( "Unable to probe KV version for '%s': %v", path, err )
+	}
+	switch probe.Data.Options.Version {
+	case "2":
+		return 2, nil
+	default:
+		return 1, nil
+	}
+}
+
+// kvReadPath rewrites path for reading, inserting /data/ after the
+// mount for KV v2.
+func kvReadPath( path string, version int ) string {
+	if version != 2 {
+		return path
+	}
+
+	return insertAfterMount( path, "data" )
+}
+
+// kvListPath rewrites path for listing, inserting /metadata/ after the
+// mount for KV v2.
+func kvListPath( path string, version int ) string {
+	if version != 2 {
+		return path
+	}
+
+	return insertAfterMount( path, "metadata" )
+}
+
+// insertAfterMount inserts segment immediately after the first path
+// component of path, i.e. after the secret engine's mount point.
+func insertAfterMount( path string, segment string ) string {
+	for i := 0; i != len( path ); i++ {
+		if path[i] == '/' {
+			return path[:i] + "/" + segment + path[i:]
+		}
+	}
+
+	return path + "/" + segment
+}
+
+// Read fetches the secret at path and returns it as a config, unwrapping
+// the KV v2 {"data": {"data": ..., "metadata": ...}} envelope if
+// necessary.
+func ( vc *vaultConfig ) Read( path string ) ( config, error ) {
+	version, err := vc.kvVersion( path )
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := vc.vaultRequest( "GET", kvReadPath( path, version ), &raw ); err != nil {
+		return nil, fmt.Errorf( "Unable to read Vault secret '%s': %v", path, err )
+	}
+	if version == 1 {
+		var result config
+		if err := json.Unmarshal( raw.Data, &result ); err != nil {
+			return nil, fmt.Errorf( "Unable to decode KV v1 secret '%s': %v", path, err )
+		}
+
+		return result, nil
+	}
+	var v2 struct {
+		Data config `json:"data"`
+	}
+	if err := json.Unmarshal( raw.Data, &v2 ); err != nil {
+		return nil, fmt.Errorf( "Unable to decode KV v2 secret '%s': %v", path, err )
+	}
+
+	return v2.Data, nil
+}
+
+// version returns the current KV v2 version number of the secret at
+// path, or 1 forever for a KV v1 mount (which has no version concept).
+func ( vc *vaultConfig ) version( path string ) ( int, error ) {
+	kvVersion, err := vc.kvVersion( path )
+	if err != nil {
+		return 0, err
+	}
+	if kvVersion == 1 {
+		return 1, nil
+	}
+	var meta struct {
+		Data struct {
+			Data struct {
+				CurrentVersion int `json:"current_version"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	if err := vc.vaultRequest( "GET", kvListPath( path, kvVersion ), &meta ); err != nil {
+		return 0, fmt.Errorf( "Unable to read metadata for '%s': %v", path, err )
+	}
+
+	return meta.Data.Data.CurrentVersion, nil
+}
+
+// renewSelf renews the client's own token and returns the new lease
+// duration.
+func ( vc *vaultConfig ) renewSelf() ( time.Duration, error ) {
+	var result struct {
+		Auth struct {
+			LeaseDuration int `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := vc.vaultRequest( "POST", "auth/token/renew-self", &result ); err != nil {
+		return 0, fmt.Errorf( "Unable to renew Vault token: %v", err )
+	}
+
+	return time.Duration( result.Auth.LeaseDuration ) * time.Second, nil
+}
+
+// RenewLoop renews vc's token forever, at dVaultRenewFraction of each
+// granted lease duration, until stopChan is closed. Renewal errors are
+// logged by the caller via errChan; RenewLoop keeps retrying after a
+// failure using the last known lease duration.
+func ( vc *vaultConfig ) RenewLoop( leaseDuration time.Duration, stopChan <-chan struct{}, errChan chan<- error ) {
+	for {
+		select {
+		case <-stopChan:
+			return
+
+		case <-time.After( time.Duration( float64( leaseDuration ) * dVaultRenewFraction ) ):
+			newDuration, err := vc.renewSelf()
+			if err != nil {
+				errChan <- fmt.Errorf( "Vault token renewal failed: %v", err )
+				continue
+			}
+			leaseDuration = newDuration
+		}
+	}
+}
+
+// getVaultConf obtains the Vault bootstrap section from the local
+// config, if any. ok is false if no "vault" section is configured, in
+// which case the caller should fall back to using conf as-is.
+func getVaultConf( conf config ) ( vc *vaultConfig, path string, ok bool, err error ) {
+	vaultConf, err := conf.GetSubConfig( kVault )
+	if err != nil {
+		return nil, "", false, nil
+	}
+	addr, err := vaultConf.GetString( kVaultAddr )
+	if err != nil {
+		return nil, "", false, fmt.Errorf( "Unable to read Vault address: %v", err )
+	}
+	token, err := vaultConf.GetString( kVaultToken )
+	if err != nil {
+		return nil, "", false, fmt.Errorf( "Unable to read Vault token: %v", err )
+	}
+	path, err = vaultConf.GetString( kVaultPath )
+	if err != nil {
+		return nil, "", false, fmt.Errorf( "Unable to read Vault secret path: %v", err )
+	}
+
+	return newVaultConfig( addr, token ), path, true, nil
+}
+
+// resolveConfig returns the effective configuration: conf itself, or,
+// if conf has a "vault" bootstrap section, the configuration read live
+// from Vault at the configured path. The returned vaultConfig and path
+// are non-nil only in the Vault case, so the caller can set up renewal
+// and hot-reload.
+func resolveConfig( conf config ) ( config, *vaultConfig, string, error ) {
+	vc, path, ok, err := getVaultConf( conf )
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if !ok {
+		return conf, nil, "", nil
+	}
+	liveConf, err := vc.Read( path )
+	if err != nil {
+		return nil, nil, "", fmt.Errorf( "Unable to read initial configuration from Vault: %v", err )
+	}
+
+	return liveConf, vc, path, nil
+}
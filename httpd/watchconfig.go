@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import(
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"log"
+	"reflect"
+)
+
+// ConfigWatcher applies changes to the on-disk configuration file (the
+// one read by getConfig) to a running scheduler without requiring a
+// process restart. Unlike WatchVaultConfig and WatchCommands, which
+// poll or subscribe to an external KV backend, ConfigWatcher reacts to
+// edits to the literal file passed on the command line, via viper's
+// own filesystem watch.
+type ConfigWatcher struct {
+	sched *scheduler
+
+	// connConf is the scheduler's connection configuration (the
+	// scheduler section minus kCommands) as of the last applied
+	// configuration, used to detect edits reload cannot apply live (see
+	// reload).
+	connConf config
+}
+
+// NewConfigWatcher starts watching the configuration file read by
+// getConfig for changes, applying each valid change to sched's
+// commands via reloadCommands. An edit that fails to parse or to
+// validate is logged and left unapplied, so a bad edit cannot take
+// partial effect; sched keeps running with its last good configuration.
+// schedConf is sched's own scheduler configuration as started, used to
+// detect edits to the bus connection itself (see reload).
+func NewConfigWatcher( sched *scheduler, schedConf config ) *ConfigWatcher {
+	cw := &ConfigWatcher{ sched: sched, connConf: connectionConf( schedConf ) }
+	viper.OnConfigChange( func( e fsnotify.Event ) {
+		cw.reload()
+	} )
+	viper.WatchConfig()
+
+	return cw
+}
+
+// connectionConf returns the parts of schedConf which configure the
+// underlying modbus connection (address, baud rate, pool size, and so
+// on), excluding kCommands, so they can be compared across reloads
+// without a change to the command set alone looking like a connection
+// change.
+func connectionConf( schedConf config ) config {
+	result := make( config, len( schedConf ) )
+	for k, v := range schedConf {
+		if k == kCommands {
+			continue
+		}
+		result[k] = v
+	}
+
+	return result
+}
+
+// reload re-reads the configuration file already loaded by viper,
+// validates every command in it before touching anything live, and, if
+// valid, reconciles sched's commands against it via reloadCommands. If
+// the connection itself (bus address, serial line parameters, pool
+// size) changed too, it is applied first, via reconnect: the running
+// scheduler's Pool is torn down and rebuilt against the new settings,
+// and every existing command is re-added to it, before reloadCommands
+// reconciles the command set itself.
+func ( cw *ConfigWatcher ) reload() {
+	newConf := config( viper.AllSettings() )
+	schedConf, err := newConf.GetSubConfig( kScheduler )
+	if err != nil {
+		log.Printf( "Config reload: no scheduler section in updated configuration: %v", err )
+		return
+	}
+	commandsConf, err := schedConf.GetSubConfig( kCommands )
+	if err != nil {
+		log.Printf( "Config reload: no commands section in updated configuration: %v", err )
+		return
+	}
+	if err := validateCommandsConf( commandsConf ); err != nil {
+		log.Printf( "Config reload: rejecting invalid configuration: %v", err )
+		return
+	}
+	newConnConf := connectionConf( schedConf )
+	if !reflect.DeepEqual( newConnConf, cw.connConf ) {
+		if err := cw.sched.reconnect( schedConf ); err != nil {
+			log.Printf( "Config reload: unable to apply changed scheduler connection settings: %v", err )
+			return
+		}
+		cw.connConf = newConnConf
+		log.Printf( "Config reload: applied changed scheduler connection settings" )
+	}
+	if err := cw.sched.reloadCommands( commandsConf ); err != nil {
+		log.Printf( "Config reload: unable to apply updated configuration: %v", err )
+		return
+	}
+	log.Printf( "Config reload: applied updated configuration" )
+}
+
+// validateCommandsConf parses every command in commandsConf the same
+// way fillCommand does, without creating or touching any live
+// schedule, so a malformed edit is rejected atomically instead of
+// partially applied.
+func validateCommandsConf( commandsConf config ) error {
+	for name := range commandsConf {
+		commandConf, err := commandsConf.GetSubConfig( name )
+		if err != nil {
+			return fmt.Errorf( "Command configuration error for '%s': %v", name, err )
+		}
+		if _, err := getScheduleConf( commandConf ); err != nil {
+			return fmt.Errorf( "Unable to get schedule configuration for command '%s': %v", name, err )
+		}
+		if _, _, err := getCommandAddress( commandConf ); err != nil {
+			return fmt.Errorf( "Unable to get address information for command '%s': %v", name, err )
+		}
+		if _, err := commandConf.GetString( kType ); err != nil {
+			return fmt.Errorf( "Unable to get type of command '%s': %v", name, err )
+		}
+		quantity, _ := commandConf.GetUInt16( kQuantity )
+		if err := checkQuantityBound( quantity, name ); err != nil {
+			return err
+		}
+		if _, err := getRetryPolicyConf( commandConf ); err != nil {
+			return fmt.Errorf( "Unable to get retry configuration for command '%s': %v", name, err )
+		}
+		if _, err := getDecoderConf( getValuesConf( commandConf ) ); err != nil {
+			return fmt.Errorf( "Unable to get values configuration for command '%s': %v", name, err )
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package mbhttp
+
+import(
+	"sync"
+	"time"
+)
+
+// RecordedError is a single error captured by an ErrorWindow, with the
+// time it was recorded.
+type RecordedError struct {
+	// Time is when the error was recorded.
+	Time time.Time
+
+	// Error is the recorded error's message.
+	Error string
+}
+
+// ErrorWindow counts errors occurring within a rolling time window,
+// resetting the count once the window has elapsed since the last
+// reset, and remembers the most recent errors in a ring buffer. It is
+// the reusable form of the counting logic that previously lived
+// inline in httpd's watchSchedulerErrors, so the /commands HTTP
+// handlers can report the same counters the fatal-error watcher acts
+// on.
+type ErrorWindow struct {
+	// resetAfter is how long the window lasts before Record starts a
+	// new one.
+	resetAfter time.Duration
+
+	// backlog is the maximum number of recent errors kept.
+	backlog int
+
+	// mutex protects the fields below.
+	mutex sync.Mutex
+
+	// lastReset is when the current window started.
+	lastReset time.Time
+
+	// count is the number of errors recorded in the current window.
+	count int
+
+	// recent holds the last backlog recorded errors, oldest first.
+	recent []RecordedError
+}
+
+// NewErrorWindow creates an ErrorWindow which resets its count after
+// resetAfter has elapsed since the last reset, and remembers up to
+// backlog of the most recent errors.
+func NewErrorWindow( resetAfter time.Duration, backlog int ) *ErrorWindow {
+	return &ErrorWindow{
+		resetAfter: resetAfter,
+		backlog: backlog,
+		lastReset: time.Now(),
+	}
+}
+
+// Record records err, resetting the window if resetAfter has elapsed
+// since the last reset, and returns the resulting in-window count.
+func ( w *ErrorWindow ) Record( err error ) int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	now := time.Now()
+	if now.Sub( w.lastReset ) > w.resetAfter {
+		w.count = 0
+		w.lastReset = now
+	}
+	w.count++
+	w.recent = append( w.recent, RecordedError{ Time: now, Error: err.Error() } )
+	if len( w.recent ) > w.backlog {
+		w.recent = w.recent[len( w.recent ) - w.backlog:]
+	}
+
+	return w.count
+}
+
+// Snapshot reports the window's current count, the time the current
+// window started, and the most recently recorded errors, oldest
+// first.
+func ( w *ErrorWindow ) Snapshot() ( count int, lastReset time.Time, recent []RecordedError ) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	recent = make( []RecordedError, len( w.recent ) )
+	copy( recent, w.recent )
+
+	return w.count, w.lastReset, recent
+}
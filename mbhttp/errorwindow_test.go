@@ -0,0 +1,66 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package mbhttp
+
+import(
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestErrorWindowCounts( t *testing.T ) {
+	w := NewErrorWindow( time.Hour, 2 )
+	if count := w.Record( errors.New( "first" ) ); count != 1 {
+		t.Errorf( "Expected count 1 after first error, got %d", count )
+	}
+	if count := w.Record( errors.New( "second" ) ); count != 2 {
+		t.Errorf( "Expected count 2 after second error, got %d", count )
+	}
+	count, _, recent := w.Snapshot()
+	if count != 2 {
+		t.Errorf( "Expected snapshot count 2, got %d", count )
+	}
+	if len( recent ) != 2 || recent[0].Error != "first" || recent[1].Error != "second" {
+		t.Errorf( "Unexpected recent errors: %+v", recent )
+	}
+}
+
+func TestErrorWindowBacklogTruncation( t *testing.T ) {
+	w := NewErrorWindow( time.Hour, 1 )
+	w.Record( errors.New( "first" ) )
+	w.Record( errors.New( "second" ) )
+	_, _, recent := w.Snapshot()
+	if len( recent ) != 1 || recent[0].Error != "second" {
+		t.Errorf( "Expected backlog to keep only the most recent error, got %+v", recent )
+	}
+}
+
+func TestErrorWindowReset( t *testing.T ) {
+	w := NewErrorWindow( time.Millisecond, 5 )
+	w.Record( errors.New( "first" ) )
+	time.Sleep( 2 * time.Millisecond )
+	count := w.Record( errors.New( "second" ) )
+	if count != 1 {
+		t.Errorf( "Expected count to reset to 1 after window elapsed, got %d", count )
+	}
+}
@@ -0,0 +1,309 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package mbhttp serves a read-only, JSON-over-HTTP view of a
+// scheduler's commands, independent of the per-command locations an
+// operator configures under httpd.locations: one fixed set of
+// /commands endpoints listing every command, exposing its latest
+// scratchpad value and recent scheduler error counters, streaming
+// updates as they arrive, and (for write commands) triggering a
+// one-shot execution. It deliberately knows nothing about package
+// main's commandConfig or Scratchpad types, so the caller adapts them
+// to Command when registering.
+package mbhttp
+
+import(
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sample is a single timestamped scratchpad value, as reported by a
+// Command's Get and Subscribe.
+type Sample struct {
+	// Time is the sample's update time.
+	Time time.Time
+
+	// Data is the sample's raw scratchpad data.
+	Data []byte
+}
+
+// Command adapts one scheduler command for the /commands endpoints.
+// The caller constructs one per command, wrapping whatever concrete
+// scratchpad/launcher it actually has.
+type Command struct {
+	// Name is the command's name, as used in the scheduler's
+	// configuration.
+	Name string
+
+	// IsReadCommand reports whether this is a read command; Launch is
+	// only called for commands where this is false.
+	IsReadCommand bool
+
+	// Get returns the most recent sample, or a nil Data if none has
+	// been recorded yet.
+	Get func() Sample
+
+	// Subscribe streams every sample recorded after the call. The
+	// returned cancel function must be called once the subscriber is
+	// no longer interested.
+	Subscribe func() ( <-chan Sample, func() )
+
+	// Launch triggers a one-shot execution of a write command. It is
+	// nil for read commands.
+	Launch func() error
+}
+
+// Registry holds the currently known Commands of a running scheduler
+// and serves them over HTTP. Commands can be added and removed while
+// the Registry is in use, so dynamic reconfiguration (see
+// scheduler.AddCommand/RemoveCommand) is reflected immediately.
+type Registry struct {
+	// errWindow is the scheduler-wide error window reported alongside
+	// every command.
+	errWindow *ErrorWindow
+
+	// mutex protects commands.
+	mutex sync.Mutex
+
+	// commands holds the currently registered commands, by name.
+	commands map[string]*Command
+}
+
+// NewRegistry creates an empty Registry reporting errWindow's
+// counters alongside every command.
+func NewRegistry( errWindow *ErrorWindow ) *Registry {
+	return &Registry{
+		errWindow: errWindow,
+		commands: make( map[string]*Command ),
+	}
+}
+
+// Register adds or replaces the command cmd under its Name.
+func ( reg *Registry ) Register( cmd *Command ) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.commands[cmd.Name] = cmd
+}
+
+// Unregister removes the command named name, if any.
+func ( reg *Registry ) Unregister( name string ) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	delete( reg.commands, name )
+}
+
+// get looks up the command named name.
+func ( reg *Registry ) get( name string ) ( *Command, bool ) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	cmd, ok := reg.commands[name]
+
+	return cmd, ok
+}
+
+// names returns the currently registered command names, sorted.
+func ( reg *Registry ) names() []string {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	result := make( []string, 0, len( reg.commands ) )
+	for name := range reg.commands {
+		result = append( result, name )
+	}
+	sort.Strings( result )
+
+	return result
+}
+
+// errorWindowJSON is the JSON representation of an ErrorWindow
+// snapshot.
+type errorWindowJSON struct {
+	Count int `json:"count"`
+	LastReset time.Time `json:"lastReset"`
+	Recent []RecordedError `json:"recent,omitempty"`
+}
+
+// errorWindowJSON snapshots reg.errWindow for embedding in a
+// response.
+func ( reg *Registry ) errorWindowJSON() errorWindowJSON {
+	count, lastReset, recent := reg.errWindow.Snapshot()
+
+	return errorWindowJSON{ Count: count, LastReset: lastReset, Recent: recent }
+}
+
+// sampleJSON is the JSON representation of a command's latest
+// sample: the raw data as hex, plus a best-effort decoding as
+// big-endian uint16 registers (the natural width of a modbus
+// register). The typed decoding of package decode is not applied
+// here.
+type sampleJSON struct {
+	Time time.Time `json:"time,omitempty"`
+	Hex string `json:"hex,omitempty"`
+	Registers []uint16 `json:"registers,omitempty"`
+}
+
+// toSampleJSON converts sample to its JSON representation.
+func toSampleJSON( sample Sample ) sampleJSON {
+	if sample.Data == nil {
+		return sampleJSON{}
+	}
+	registers := make( []uint16, len( sample.Data ) / 2 )
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16( sample.Data[2 * i : 2 * i + 2] )
+	}
+
+	return sampleJSON{
+		Time: sample.Time,
+		Hex: hex.EncodeToString( sample.Data ),
+		Registers: registers,
+	}
+}
+
+// commandJSON is the JSON representation of a command served by
+// GET /commands/{name}.
+type commandJSON struct {
+	Name string `json:"name"`
+	Sample sampleJSON `json:"sample"`
+	Errors errorWindowJSON `json:"errors"`
+}
+
+// writeJSON writes obj as the JSON body of a response with the given
+// status code.
+func writeJSON( w http.ResponseWriter, code int, obj interface{} ) {
+	w.Header().Set( "Content-Type", "application/json" )
+	w.WriteHeader( code )
+	if err := json.NewEncoder( w ).Encode( obj ); err != nil {
+		log.Printf( "mbhttp: unable to write JSON response: %v", err )
+	}
+}
+
+// ServeHTTP dispatches GET /commands, GET /commands/{name},
+// GET /commands/{name}/stream, and POST /commands/{name}.
+func ( reg *Registry ) ServeHTTP( w http.ResponseWriter, r *http.Request ) {
+	rest := strings.TrimPrefix( r.URL.Path, "/commands" )
+	rest = strings.TrimPrefix( rest, "/" )
+	if rest == "" {
+		reg.listHandler( w, r )
+		return
+	}
+	parts := strings.SplitN( rest, "/", 2 )
+	switch {
+	case len( parts ) == 1:
+		reg.commandHandler( w, r, parts[0] )
+	case len( parts ) == 2 && parts[1] == "stream":
+		reg.streamHandler( w, r, parts[0] )
+	default:
+		w.WriteHeader( http.StatusNotFound )
+	}
+}
+
+// listHandler serves GET /commands: the sorted names of all currently
+// registered commands.
+func ( reg *Registry ) listHandler( w http.ResponseWriter, r *http.Request ) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader( http.StatusMethodNotAllowed )
+		return
+	}
+	writeJSON( w, http.StatusOK, reg.names() )
+}
+
+// commandHandler serves GET and POST /commands/{name}.
+func ( reg *Registry ) commandHandler( w http.ResponseWriter, r *http.Request, name string ) {
+	cmd, ok := reg.get( name )
+	if !ok {
+		w.WriteHeader( http.StatusNotFound )
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON( w, http.StatusOK, commandJSON{
+			Name: name,
+			Sample: toSampleJSON( cmd.Get() ),
+			Errors: reg.errorWindowJSON(),
+		} )
+	case http.MethodPost:
+		if cmd.IsReadCommand || cmd.Launch == nil {
+			w.WriteHeader( http.StatusMethodNotAllowed )
+			return
+		}
+		if err := cmd.Launch(); err != nil {
+			w.WriteHeader( http.StatusInternalServerError )
+			fmt.Fprintf( w, "Unable to launch command '%s': %v", name, err )
+			return
+		}
+		w.WriteHeader( http.StatusOK )
+	default:
+		w.WriteHeader( http.StatusMethodNotAllowed )
+	}
+}
+
+// streamHandler serves GET /commands/{name}/stream: a Server-Sent
+// Events stream of every sample Update()d from now on.
+func ( reg *Registry ) streamHandler( w http.ResponseWriter, r *http.Request, name string ) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader( http.StatusMethodNotAllowed )
+		return
+	}
+	cmd, ok := reg.get( name )
+	if !ok {
+		w.WriteHeader( http.StatusNotFound )
+		return
+	}
+	flusher, ok := w.( http.Flusher )
+	if !ok {
+		w.WriteHeader( http.StatusInternalServerError )
+		log.Print( "mbhttp: streaming not supported by response writer" )
+		return
+	}
+	updates, cancel := cmd.Subscribe()
+	defer cancel()
+	w.Header().Set( "Content-Type", "text/event-stream" )
+	w.WriteHeader( http.StatusOK )
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case sample, ok := <-updates:
+			if !ok {
+				return
+			}
+			blob, err := json.Marshal( toSampleJSON( sample ) )
+			if err != nil {
+				log.Printf( "mbhttp: unable to marshal stream event for '%s': %v", name, err )
+				return
+			}
+			if _, err := fmt.Fprintf( w, "data: %s\n\n", blob ); err != nil {
+				log.Printf( "mbhttp: unable to write stream event for '%s': %v", name, err )
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
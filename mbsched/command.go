@@ -22,6 +22,13 @@ SOFTWARE.
 
 package mbsched
 
+import(
+	"fmt"
+	"github.com/goburrow/modbus"
+	"github.com/TheCount/goodbus/mbsched/plugin"
+	"time"
+)
+
 // command is a generic modbus command.
 type command struct {
 	// resultChan is the command's result channel.
@@ -29,11 +36,29 @@ type command struct {
 
 	// execFunc is the modbus function to be executed.
 	execFunc func() ( []byte, error )
+
+	// name identifies the command in the RetryReports sent to
+	// retryReportChan.
+	name string
+
+	// retry configures Execute's backoff loop for transient errors.
+	// The zero value disables retries.
+	retry RetryPolicy
+
+	// retryReportChan receives a RetryReport whenever Execute needed
+	// more than one attempt. Nil disables reporting.
+	retryReportChan chan<- RetryReport
 }
 
-// Execute executes the command.
+// Execute executes the command, retrying transient errors according to
+// c.retry before giving up. The result channel only receives a value,
+// and retryReportChan only receives a RetryReport, once the command has
+// either succeeded or exhausted its retries.
 func ( c *command ) Execute() error {
-	result, err := c.execFunc()
+	result, err, attempts := c.executeWithRetry()
+	if attempts > 1 {
+		c.reportRetry( attempts, err )
+	}
 	if err != nil {
 		return err
 	}
@@ -43,70 +68,274 @@ func ( c *command ) Execute() error {
 	return nil
 }
 
+// executeWithRetry runs execFunc, retrying while c.retry.classify
+// reports the error as retryable and neither MaxRetries nor
+// MaxElapsedTime has been exceeded. It returns the last result and
+// error seen, along with the total number of attempts made.
+func ( c *command ) executeWithRetry() ( []byte, error, int ) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		result, err := c.execFunc()
+		if err == nil {
+			return result, nil, attempt
+		}
+		if attempt > c.retry.MaxRetries || c.retry.classify( err ) == NoRetry {
+			return nil, err, attempt
+		}
+		wait := c.retry.backoff( attempt )
+		if c.retry.MaxElapsedTime > 0 && time.Since( start ) + wait > c.retry.MaxElapsedTime {
+			return nil, err, attempt
+		}
+		time.Sleep( wait )
+	}
+}
+
+// reportRetry sends a RetryReport for a command.Execute call which took
+// attempts tries, with err nil on eventual success or the terminal
+// error on exhausted retries. It does nothing if no retryReportChan was
+// configured.
+func ( c *command ) reportRetry( attempts int, err error ) {
+	if c.retryReportChan == nil {
+		return
+	}
+	c.retryReportChan <- RetryReport{ Name: c.name, Attempts: attempts, Err: err }
+}
+
 // Finalize closes the command's result channel.
 func ( c *command ) Finalize() {
 	close( c.resultChan )
 }
 
-// newCommand creates a new command with nil execFunc.
+// newCommand creates a new command with nil execFunc, named name and
+// retried according to retry, reporting retries on retryReportChan.
 // A channel with a buffer size of bufSize
 // yielding the command's results is returned alongside.
 // A negative buffer size will cause a panic.
-func newCommand( bufSize int ) ( *command, <-chan []byte ) {
+func newCommand( bufSize int, name string, retry RetryPolicy, retryReportChan chan<- RetryReport ) ( *command, <-chan []byte ) {
 	resultChan := make( chan []byte, bufSize )
 	return &command{
 		resultChan: resultChan,
+		name: name,
+		retry: retry,
+		retryReportChan: retryReportChan,
 	}, resultChan
 }
 
 // newReadInputRegisters creates a new modbus read input registers command.
+// The command is dispatched through pool, so a slow slave only stalls the
+// pool worker handling it, not the scheduler's dispatch loop.
+// Execution retries according to retry, named name in the
+// RetryReports sent to retryReportChan.
 // A channel with a buffer size of bufSize
 // yielding the command's results is returned alongside.
 // A negative buffer size will cause a panic.
-func newReadInputRegisters( bufSize int, handler handler, slaveId byte, address uint16, quantity uint16 ) ( *command, <-chan []byte ) {
-	command, resultChan := newCommand( bufSize )
+func newReadInputRegisters( bufSize int, pool Pool, slaveId byte, address uint16, quantity uint16, name string, retry RetryPolicy, retryReportChan chan<- RetryReport ) ( *command, <-chan []byte ) {
+	command, resultChan := newCommand( bufSize, name, retry, retryReportChan )
 	command.execFunc = func() ( []byte, error ) {
-		return handler.MakeClient( slaveId ).ReadInputRegisters( address, quantity )
+		var result []byte
+		err := pool.Execute( slaveId, func( client modbus.Client ) ( err error ) {
+			result, err = client.ReadInputRegisters( address, quantity )
+			return
+		} )
+
+		return result, err
 	}
 
 	return command, resultChan
 }
 
 // newReadHoldingRegisters creates a new modbus read holding registers command.
+// The command is dispatched through pool, so a slow slave only stalls the
+// pool worker handling it, not the scheduler's dispatch loop.
+// Execution retries according to retry, named name in the
+// RetryReports sent to retryReportChan.
 // A channel with a buffer size of bufSize
 // yielding the command's results is returned alongside.
 // A negative buffer size will cause a panic.
-func newReadHoldingRegisters( bufSize int, handler handler, slaveId byte, address uint16, quantity uint16 ) ( *command, <-chan []byte ) {
-	command, resultChan := newCommand( bufSize )
+func newReadHoldingRegisters( bufSize int, pool Pool, slaveId byte, address uint16, quantity uint16, name string, retry RetryPolicy, retryReportChan chan<- RetryReport ) ( *command, <-chan []byte ) {
+	command, resultChan := newCommand( bufSize, name, retry, retryReportChan )
 	command.execFunc = func() ( []byte, error ) {
-		return handler.MakeClient( slaveId ).ReadHoldingRegisters( address, quantity )
+		var result []byte
+		err := pool.Execute( slaveId, func( client modbus.Client ) ( err error ) {
+			result, err = client.ReadHoldingRegisters( address, quantity )
+			return
+		} )
+
+		return result, err
 	}
 
 	return command, resultChan
 }
 
 // newWriteSingleRegister creates a new modbus write single register command.
+// The command is dispatched through pool, so a slow slave only stalls the
+// pool worker handling it, not the scheduler's dispatch loop.
+// Execution retries according to retry, named name in the
+// RetryReports sent to retryReportChan.
 // A channel with a buffer size of bufSize
 // yielding the command's results is returned alongside.
 // A negative buffer size will cause a panic.
-func newWriteSingleRegister( bufSize int, handler handler, slaveId byte, address uint16, value uint16 ) ( *command, <-chan []byte ) {
-	command, resultChan := newCommand( bufSize )
+func newWriteSingleRegister( bufSize int, pool Pool, slaveId byte, address uint16, value uint16, name string, retry RetryPolicy, retryReportChan chan<- RetryReport ) ( *command, <-chan []byte ) {
+	command, resultChan := newCommand( bufSize, name, retry, retryReportChan )
 	command.execFunc = func() ( []byte, error ) {
-		return handler.MakeClient( slaveId ).WriteSingleRegister( address, value )
+		var result []byte
+		err := pool.Execute( slaveId, func( client modbus.Client ) ( err error ) {
+			result, err = client.WriteSingleRegister( address, value )
+			return
+		} )
+
+		return result, err
 	}
 
 	return command, resultChan
 }
 
 // newWriteMultipleRegisters creates a new modbus write multiple registers command.
+// The command is dispatched through pool, so a slow slave only stalls the
+// pool worker handling it, not the scheduler's dispatch loop.
 // The length of the values slice must be exactly twice the quantity.
+// Execution retries according to retry, named name in the
+// RetryReports sent to retryReportChan.
 // A channel with a buffer size of bufSize
 // yielding the command's results is returned alongside.
 // A negative buffer size will cause a panic.
-func newWriteMultipleRegisters( bufSize int, handler handler, slaveId byte, address uint16, quantity uint16, values []byte ) ( *command, <-chan []byte ) {
-	command, resultChan := newCommand( bufSize )
+func newWriteMultipleRegisters( bufSize int, pool Pool, slaveId byte, address uint16, quantity uint16, values []byte, name string, retry RetryPolicy, retryReportChan chan<- RetryReport ) ( *command, <-chan []byte ) {
+	command, resultChan := newCommand( bufSize, name, retry, retryReportChan )
 	command.execFunc = func() ( []byte, error ) {
-		return handler.MakeClient( slaveId ).WriteMultipleRegisters( address, quantity, values )
+		var result []byte
+		err := pool.Execute( slaveId, func( client modbus.Client ) ( err error ) {
+			result, err = client.WriteMultipleRegisters( address, quantity, values )
+			return
+		} )
+
+		return result, err
+	}
+
+	return command, resultChan
+}
+
+// newReadCoils creates a new modbus read coils command.
+// The command is dispatched through pool, so a slow slave only stalls the
+// pool worker handling it, not the scheduler's dispatch loop.
+// Execution retries according to retry, named name in the
+// RetryReports sent to retryReportChan.
+// A channel with a buffer size of bufSize
+// yielding the command's results is returned alongside.
+// A negative buffer size will cause a panic.
+func newReadCoils( bufSize int, pool Pool, slaveId byte, address uint16, quantity uint16, name string, retry RetryPolicy, retryReportChan chan<- RetryReport ) ( *command, <-chan []byte ) {
+	command, resultChan := newCommand( bufSize, name, retry, retryReportChan )
+	command.execFunc = func() ( []byte, error ) {
+		var result []byte
+		err := pool.Execute( slaveId, func( client modbus.Client ) ( err error ) {
+			result, err = client.ReadCoils( address, quantity )
+			return
+		} )
+
+		return result, err
+	}
+
+	return command, resultChan
+}
+
+// newReadDiscreteInputs creates a new modbus read discrete inputs command.
+// The command is dispatched through pool, so a slow slave only stalls the
+// pool worker handling it, not the scheduler's dispatch loop.
+// Execution retries according to retry, named name in the
+// RetryReports sent to retryReportChan.
+// A channel with a buffer size of bufSize
+// yielding the command's results is returned alongside.
+// A negative buffer size will cause a panic.
+func newReadDiscreteInputs( bufSize int, pool Pool, slaveId byte, address uint16, quantity uint16, name string, retry RetryPolicy, retryReportChan chan<- RetryReport ) ( *command, <-chan []byte ) {
+	command, resultChan := newCommand( bufSize, name, retry, retryReportChan )
+	command.execFunc = func() ( []byte, error ) {
+		var result []byte
+		err := pool.Execute( slaveId, func( client modbus.Client ) ( err error ) {
+			result, err = client.ReadDiscreteInputs( address, quantity )
+			return
+		} )
+
+		return result, err
+	}
+
+	return command, resultChan
+}
+
+// newWriteSingleCoil creates a new modbus write single coil command.
+// value must be either 0x0000 (off) or 0xFF00 (on), per the modbus spec.
+// The command is dispatched through pool, so a slow slave only stalls the
+// pool worker handling it, not the scheduler's dispatch loop.
+// Execution retries according to retry, named name in the
+// RetryReports sent to retryReportChan.
+// A channel with a buffer size of bufSize
+// yielding the command's results is returned alongside.
+// A negative buffer size will cause a panic.
+func newWriteSingleCoil( bufSize int, pool Pool, slaveId byte, address uint16, value uint16, name string, retry RetryPolicy, retryReportChan chan<- RetryReport ) ( *command, <-chan []byte ) {
+	command, resultChan := newCommand( bufSize, name, retry, retryReportChan )
+	command.execFunc = func() ( []byte, error ) {
+		var result []byte
+		err := pool.Execute( slaveId, func( client modbus.Client ) ( err error ) {
+			result, err = client.WriteSingleCoil( address, value )
+			return
+		} )
+
+		return result, err
+	}
+
+	return command, resultChan
+}
+
+// newWriteMultipleCoils creates a new modbus write multiple coils command.
+// The command is dispatched through pool, so a slow slave only stalls the
+// pool worker handling it, not the scheduler's dispatch loop.
+// values must hold the coil states packed one bit per coil, i.e. exactly
+// (quantity+7)/8 bytes.
+// Execution retries according to retry, named name in the
+// RetryReports sent to retryReportChan.
+// A channel with a buffer size of bufSize
+// yielding the command's results is returned alongside.
+// A negative buffer size will cause a panic.
+func newWriteMultipleCoils( bufSize int, pool Pool, slaveId byte, address uint16, quantity uint16, values []byte, name string, retry RetryPolicy, retryReportChan chan<- RetryReport ) ( *command, <-chan []byte ) {
+	command, resultChan := newCommand( bufSize, name, retry, retryReportChan )
+	command.execFunc = func() ( []byte, error ) {
+		var result []byte
+		err := pool.Execute( slaveId, func( client modbus.Client ) ( err error ) {
+			result, err = client.WriteMultipleCoils( address, quantity, values )
+			return
+		} )
+
+		return result, err
+	}
+
+	return command, resultChan
+}
+
+// newPluginCommand creates a new command whose request and response
+// PDUs are encoded and decoded by the named plugin client, for vendor
+// function codes the mbsched package does not implement natively. The
+// command is dispatched through pool, so a slow slave only stalls the
+// pool worker handling it, not the scheduler's dispatch loop.
+// Execution retries according to retry, named name in the
+// RetryReports sent to retryReportChan.
+// A channel with a buffer size of bufSize
+// yielding the command's results is returned alongside.
+// A negative buffer size will cause a panic.
+func newPluginCommand( bufSize int, pool Pool, client *plugin.Client, slaveId byte, args []byte, name string, retry RetryPolicy, retryReportChan chan<- RetryReport ) ( *command, <-chan []byte ) {
+	command, resultChan := newCommand( bufSize, name, retry, retryReportChan )
+	command.execFunc = func() ( []byte, error ) {
+		encoded, err := client.Encode( plugin.EncodeRequest{ SlaveId: slaveId, Args: args } )
+		if err != nil {
+			return nil, fmt.Errorf( "Plugin command encode failed: %v", err )
+		}
+		response, err := pool.ExecuteRaw( slaveId, modbus.ProtocolDataUnit{ FunctionCode: encoded.FunctionCode, Data: encoded.Data } )
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := client.Decode( plugin.DecodeRequest{ Data: response } )
+		if err != nil {
+			return nil, fmt.Errorf( "Plugin command decode failed: %v", err )
+		}
+
+		return decoded.Result, nil
 	}
 
 	return command, resultChan
@@ -0,0 +1,97 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package plugin
+
+import(
+	"bufio"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os/exec"
+)
+
+// Client is a handle to a running plugin subprocess.
+type Client struct {
+	cmd *exec.Cmd
+	conn net.Conn
+	rpcClient *rpc.Client
+}
+
+// Dial launches the plugin binary at path, completes the handshake,
+// and connects to it over the resulting Unix socket.
+func Dial( path string, args ...string ) ( *Client, error ) {
+	cmd := exec.Command( path, args... )
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to obtain stdout pipe for plugin '%s': %v", path, err )
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf( "Unable to start plugin '%s': %v", path, err )
+	}
+
+	socketPath, err := readHandshake( bufio.NewReader( stdout ) )
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf( "Plugin '%s' handshake failed: %v", path, err )
+	}
+
+	conn, err := net.Dial( "unix", socketPath )
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf( "Unable to dial plugin '%s' at '%s': %v", path, socketPath, err )
+	}
+
+	return &Client{
+		cmd: cmd,
+		conn: conn,
+		rpcClient: rpc.NewClient( conn ),
+	}, nil
+}
+
+// Encode calls the plugin's Command.Encode method.
+func ( c *Client ) Encode( req EncodeRequest ) ( EncodeReply, error ) {
+	var reply EncodeReply
+	err := c.rpcClient.Call( "Command.Encode", req, &reply )
+
+	return reply, err
+}
+
+// Decode calls the plugin's Command.Decode method.
+func ( c *Client ) Decode( req DecodeRequest ) ( DecodeReply, error ) {
+	var reply DecodeReply
+	err := c.rpcClient.Call( "Command.Decode", req, &reply )
+
+	return reply, err
+}
+
+// Close closes the connection to the plugin and waits for its process
+// to exit.
+func ( c *Client ) Close() error {
+	err := c.rpcClient.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+
+	return err
+}
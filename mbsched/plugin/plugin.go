@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package plugin implements the out-of-process protocol vendor-specific
+// modbus function codes are loaded with. A plugin is a standalone binary
+// which, on startup, listens on a Unix socket and calls Serve with its
+// Command implementation. The goodbus process launches the plugin binary,
+// reads its handshake line to learn the socket to dial, and talks to it
+// over net/rpc (gob-encoded) from then on.
+//
+// A plugin never touches the physical bus itself: it only encodes the
+// request PDU for a command and decodes the corresponding response PDU.
+// The goodbus process performs the actual wire I/O via the plugin's
+// slave connection pool, so a plugin cannot block or corrupt bus access
+// for unrelated commands, and a crashing plugin only fails the RPC call
+// in flight rather than the scheduler's dispatch loop.
+package plugin
+
+import(
+	"bufio"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is incremented whenever the EncodeRequest/EncodeReply
+// or DecodeRequest/DecodeReply shapes change incompatibly. Dial rejects
+// a plugin whose handshake reports a different version.
+const ProtocolVersion = 1
+
+// handshakePrefix begins the line a plugin writes to stdout once it is
+// ready to accept connections: "GOODBUS-PLUGIN|<version>|unix|<socket>".
+const handshakePrefix = "GOODBUS-PLUGIN"
+
+// EncodeRequest carries the parameters needed to build the request PDU
+// for one invocation of a plugin command.
+type EncodeRequest struct {
+	// SlaveId is the modbus slave ID the command targets.
+	SlaveId byte
+
+	// Args is the plugin-specific, opaque argument blob configured for
+	// this command, e.g. via AddPluginCommand.
+	Args []byte
+}
+
+// EncodeReply is a plugin command's request PDU.
+type EncodeReply struct {
+	// FunctionCode is the modbus function code of the request.
+	FunctionCode byte
+
+	// Data is the function-code-specific payload of the request.
+	Data []byte
+}
+
+// DecodeRequest carries the raw response PDU data goodbus received for
+// a plugin command's request.
+type DecodeRequest struct {
+	// Data is the function-code-specific payload of the response.
+	Data []byte
+}
+
+// DecodeReply is the result a plugin command extracted from a response
+// PDU, handed back to the caller of AddPluginCommand's result channel
+// unmodified.
+type DecodeReply struct {
+	Result []byte
+}
+
+// Command is implemented by a plugin to encode the request PDU for one
+// of its commands and decode the corresponding response PDU. It is
+// registered as an RPC service named "Command" by Serve.
+type Command interface {
+	// Encode builds the request PDU for req.
+	Encode( req EncodeRequest, reply *EncodeReply ) error
+
+	// Decode extracts the command result from a response PDU.
+	Decode( req DecodeRequest, reply *DecodeReply ) error
+}
+
+// Serve runs a plugin binary's main loop: it listens on a fresh Unix
+// socket in a temporary directory, writes the handshake line to
+// stdout, and serves RPC requests for cmd until the connection closes.
+// Serve does not return until then, so it is normally the last call in
+// a plugin binary's main function.
+func Serve( cmd Command ) error {
+	listener, err := net.Listen( "unix", fmt.Sprintf( "%s/goodbus-plugin-%d.sock", os.TempDir(), os.Getpid() ) )
+	if err != nil {
+		return fmt.Errorf( "Unable to listen on plugin socket: %v", err )
+	}
+	defer listener.Close()
+
+	server := rpc.NewServer()
+	if err := server.RegisterName( "Command", cmd ); err != nil {
+		return fmt.Errorf( "Unable to register plugin command: %v", err )
+	}
+
+	if _, err := fmt.Fprintf( os.Stdout, "%s|%d|unix|%s\n", handshakePrefix, ProtocolVersion, listener.Addr().String() ); err != nil {
+		return fmt.Errorf( "Unable to write plugin handshake: %v", err )
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return fmt.Errorf( "Unable to accept plugin connection: %v", err )
+	}
+	server.ServeConn( conn )
+
+	return nil
+}
+
+// readHandshake parses the handshake line written by Serve.
+func readHandshake( r *bufio.Reader ) ( socketPath string, err error ) {
+	line, err := r.ReadString( '\n' )
+	if err != nil {
+		return "", fmt.Errorf( "Unable to read plugin handshake: %v", err )
+	}
+	fields := strings.Split( strings.TrimSpace( line ), "|" )
+	if len( fields ) != 4 || fields[0] != handshakePrefix || fields[2] != "unix" {
+		return "", fmt.Errorf( "Malformed plugin handshake: '%s'", line )
+	}
+	version, err := strconv.Atoi( fields[1] )
+	if err != nil {
+		return "", fmt.Errorf( "Malformed plugin protocol version in handshake '%s': %v", line, err )
+	}
+	if version != ProtocolVersion {
+		return "", fmt.Errorf( "Plugin protocol version %d does not match expected version %d", version, ProtocolVersion )
+	}
+
+	return fields[3], nil
+}
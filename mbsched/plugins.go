@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package mbsched
+
+import(
+	"fmt"
+	"github.com/TheCount/goodbus/mbsched/plugin"
+	"os"
+	"path/filepath"
+)
+
+// PluginRegistry holds the plugin subprocesses discovered by
+// LoadPlugins, keyed by plugin name (the plugin binary's base file
+// name).
+type PluginRegistry struct {
+	clients map[string]*plugin.Client
+}
+
+// LoadPlugins launches every entry of dir that is not a directory and
+// has an execute permission bit set as a plugin subprocess and returns a registry of the resulting clients.
+// If any plugin fails to start, the plugins already started are
+// closed and an error is returned.
+func LoadPlugins( dir string ) ( *PluginRegistry, error ) {
+	entries, err := os.ReadDir( dir )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to read plugin directory '%s': %v", dir, err )
+	}
+	registry := &PluginRegistry{
+		clients: make( map[string]*plugin.Client ),
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || info.Mode() & 0111 == 0 {
+			continue
+		}
+		path := filepath.Join( dir, entry.Name() )
+		client, err := plugin.Dial( path )
+		if err != nil {
+			registry.Close()
+			return nil, fmt.Errorf( "Unable to load plugin '%s': %v", path, err )
+		}
+		registry.clients[entry.Name()] = client
+	}
+
+	return registry, nil
+}
+
+// Get returns the plugin client registered under name, or false if no
+// such plugin was loaded.
+func ( r *PluginRegistry ) Get( name string ) ( *plugin.Client, bool ) {
+	client, ok := r.clients[name]
+
+	return client, ok
+}
+
+// Close closes every plugin client in the registry.
+func ( r *PluginRegistry ) Close() error {
+	var firstErr error
+	for _, client := range r.clients {
+		if err := client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
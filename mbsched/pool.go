@@ -0,0 +1,318 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package mbsched
+
+import(
+	"fmt"
+	"github.com/goburrow/modbus"
+	"time"
+)
+
+// Pool dispatches modbus commands to one or more underlying connections.
+// On transports where it is safe to do so (TCP), a Pool can run commands
+// for different slaves concurrently instead of serializing everything
+// through a single shared connection.
+type Pool interface {
+	// Execute runs fn with a modbus.Client configured for slaveId on one
+	// of the pool's worker connections, and returns fn's result. Execute
+	// blocks the calling goroutine until fn has completed, but does not
+	// hold any pool-wide lock while fn runs, so independent callers can
+	// be serviced by independent workers concurrently.
+	Execute( slaveId byte, fn func( modbus.Client ) error ) error
+
+	// ExecuteRaw sends pdu to slaveId on one of the pool's worker
+	// connections using the connection's own Packager/Transporter, and
+	// returns the decoded response data. Unlike Execute, ExecuteRaw is
+	// not limited to the function codes modbus.Client exposes, so
+	// plugin commands can use it to speak vendor-specific function
+	// codes.
+	ExecuteRaw( slaveId byte, pdu modbus.ProtocolDataUnit ) ( []byte, error )
+
+	// Close closes all connections held by the pool.
+	Close() error
+}
+
+// poolJob is a unit of work dispatched to a pool worker goroutine.
+// Exactly one of fn or pdu is set, selecting between a modbus.Client
+// job and a raw PDU job.
+type poolJob struct {
+	slaveId byte
+	fn func( modbus.Client ) error
+	pdu *modbus.ProtocolDataUnit
+	resultChan chan<- error
+	rawResultChan chan<- []byte
+}
+
+// runJob executes job against h, honoring whichever of fn or pdu is set.
+func runJob( h handler, job poolJob ) {
+	if job.pdu != nil {
+		data, err := rawExecute( h, job.slaveId, *job.pdu )
+		job.rawResultChan <- data
+		job.resultChan <- err
+		return
+	}
+	job.resultChan <- job.fn( h.MakeClient( job.slaveId ) )
+}
+
+// rawExecute encodes, sends, verifies and decodes pdu on h, configured
+// for slaveId, using h's own Packager/Transporter, and returns the
+// decoded response's data. An exception response (function code with
+// its high bit set) is reported as an error instead of being returned
+// as data.
+func rawExecute( h handler, slaveId byte, pdu modbus.ProtocolDataUnit ) ( []byte, error ) {
+	h.MakeClient( slaveId ) // sets the slave ID for the next request on h
+	request, err := h.Encode( &pdu )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to encode raw PDU: %v", err )
+	}
+	response, err := h.Send( request )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to send raw PDU: %v", err )
+	}
+	if err := h.Verify( request, response ); err != nil {
+		return nil, fmt.Errorf( "Raw PDU response failed verification: %v", err )
+	}
+	responsePDU, err := h.Decode( response )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to decode raw PDU response: %v", err )
+	}
+	if responsePDU.FunctionCode & 0x80 != 0 {
+		exceptionCode := byte( 0 )
+		if len( responsePDU.Data ) > 0 {
+			exceptionCode = responsePDU.Data[0]
+		}
+		return nil, fmt.Errorf( "Modbus exception 0x%02x responding to function code 0x%02x", exceptionCode, pdu.FunctionCode )
+	}
+
+	return responsePDU.Data, nil
+}
+
+// tcpPool is a Pool backed by a fixed number of parallel TCP connections.
+// Workers pull jobs off a shared channel, so a slow slave only stalls the
+// one worker currently talking to it.
+type tcpPool struct {
+	jobChan chan poolJob
+	handlers []*tcpHandler
+}
+
+// NewTCPPool creates a new Pool with size parallel TCPClientHandler
+// connections to addr, dispatching jobs to whichever worker connection
+// is free. size must be positive.
+func NewTCPPool( addr string, size int, timeout time.Duration ) ( Pool, error ) {
+	if size <= 0 {
+		return nil, fmt.Errorf( "TCP pool size must be positive, got %d", size )
+	}
+	p := &tcpPool{
+		jobChan: make( chan poolJob ),
+	}
+	for i := 0; i != size; i++ {
+		h := newTcpHandler( addr, timeout )
+		if err := h.Connect(); err != nil {
+			p.Close()
+			return nil, fmt.Errorf( "Unable to connect TCP pool worker %d of %d: %v", i + 1, size, err )
+		}
+		p.handlers = append( p.handlers, h )
+		go p.work( h )
+	}
+
+	return p, nil
+}
+
+// work runs jobs for one TCP worker connection until the job channel is
+// closed.
+func ( p *tcpPool ) work( h *tcpHandler ) {
+	for job := range p.jobChan {
+		runJob( h, job )
+	}
+}
+
+// Execute implements Pool.
+func ( p *tcpPool ) Execute( slaveId byte, fn func( modbus.Client ) error ) error {
+	resultChan := make( chan error, 1 )
+	p.jobChan <- poolJob{ slaveId: slaveId, fn: fn, resultChan: resultChan }
+
+	return <-resultChan
+}
+
+// ExecuteRaw implements Pool.
+func ( p *tcpPool ) ExecuteRaw( slaveId byte, pdu modbus.ProtocolDataUnit ) ( []byte, error ) {
+	resultChan := make( chan error, 1 )
+	rawResultChan := make( chan []byte, 1 )
+	p.jobChan <- poolJob{ slaveId: slaveId, pdu: &pdu, resultChan: resultChan, rawResultChan: rawResultChan }
+
+	return <-rawResultChan, <-resultChan
+}
+
+// Close implements Pool.
+func ( p *tcpPool ) Close() error {
+	close( p.jobChan )
+	var firstErr error
+	for _, h := range p.handlers {
+		if err := h.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// serialPool is a Pool backed by a single serial (ASCII or RTU) handler.
+// All jobs necessarily serialize onto the one underlying bus, but are
+// dispatched through a worker goroutine, so Execute does not tie up the
+// caller's goroutine with anything beyond waiting for its own job's
+// result, and the bus can be shared safely from multiple goroutines.
+type serialPool struct {
+	jobChan chan poolJob
+	handler handler
+}
+
+// NewSerialPool creates a new Pool backed by a single already-configured
+// serial handler (ASCII or RTU). The handler is connected as part of
+// pool creation.
+func NewSerialPool( h handler ) ( Pool, error ) {
+	if err := h.Connect(); err != nil {
+		return nil, fmt.Errorf( "Unable to connect serial pool: %v", err )
+	}
+	p := &serialPool{
+		jobChan: make( chan poolJob ),
+		handler: h,
+	}
+	go p.work()
+
+	return p, nil
+}
+
+// work runs jobs for the serial worker until the job channel is closed.
+func ( p *serialPool ) work() {
+	for job := range p.jobChan {
+		runJob( p.handler, job )
+	}
+}
+
+// Execute implements Pool.
+func ( p *serialPool ) Execute( slaveId byte, fn func( modbus.Client ) error ) error {
+	resultChan := make( chan error, 1 )
+	p.jobChan <- poolJob{ slaveId: slaveId, fn: fn, resultChan: resultChan }
+
+	return <-resultChan
+}
+
+// ExecuteRaw implements Pool.
+func ( p *serialPool ) ExecuteRaw( slaveId byte, pdu modbus.ProtocolDataUnit ) ( []byte, error ) {
+	resultChan := make( chan error, 1 )
+	rawResultChan := make( chan []byte, 1 )
+	p.jobChan <- poolJob{ slaveId: slaveId, pdu: &pdu, resultChan: resultChan, rawResultChan: rawResultChan }
+
+	return <-rawResultChan, <-resultChan
+}
+
+// Close implements Pool.
+func ( p *serialPool ) Close() error {
+	close( p.jobChan )
+
+	return p.handler.Close()
+}
+
+// poolOpener is implemented by pools whose underlying connection(s) are
+// only established on demand, so Scheduler.Start can keep connecting
+// lazily, as it always has, instead of eagerly dialing in the
+// NewModbusXScheduler constructors.
+type poolOpener interface {
+	open() error
+}
+
+// lazySerialPool defers connecting its serial handler until open() is
+// called by Scheduler.Start.
+type lazySerialPool struct {
+	handler handler
+	pool Pool
+}
+
+// open connects the underlying serial handler and creates the real Pool.
+func ( p *lazySerialPool ) open() error {
+	pool, err := NewSerialPool( p.handler )
+	if err != nil {
+		return err
+	}
+	p.pool = pool
+
+	return nil
+}
+
+// Execute implements Pool.
+func ( p *lazySerialPool ) Execute( slaveId byte, fn func( modbus.Client ) error ) error {
+	return p.pool.Execute( slaveId, fn )
+}
+
+// ExecuteRaw implements Pool.
+func ( p *lazySerialPool ) ExecuteRaw( slaveId byte, pdu modbus.ProtocolDataUnit ) ( []byte, error ) {
+	return p.pool.ExecuteRaw( slaveId, pdu )
+}
+
+// Close implements Pool.
+func ( p *lazySerialPool ) Close() error {
+	if p.pool == nil {
+		return nil
+	}
+
+	return p.pool.Close()
+}
+
+// lazyTcpPool defers dialing its TCP connections until open() is called
+// by Scheduler.Start.
+type lazyTcpPool struct {
+	addr string
+	size int
+	timeout time.Duration
+	pool Pool
+}
+
+// open dials the underlying TCP connections and creates the real Pool.
+func ( p *lazyTcpPool ) open() error {
+	pool, err := NewTCPPool( p.addr, p.size, p.timeout )
+	if err != nil {
+		return err
+	}
+	p.pool = pool
+
+	return nil
+}
+
+// Execute implements Pool.
+func ( p *lazyTcpPool ) Execute( slaveId byte, fn func( modbus.Client ) error ) error {
+	return p.pool.Execute( slaveId, fn )
+}
+
+// ExecuteRaw implements Pool.
+func ( p *lazyTcpPool ) ExecuteRaw( slaveId byte, pdu modbus.ProtocolDataUnit ) ( []byte, error ) {
+	return p.pool.ExecuteRaw( slaveId, pdu )
+}
+
+// Close implements Pool.
+func ( p *lazyTcpPool ) Close() error {
+	if p.pool == nil {
+		return nil
+	}
+
+	return p.pool.Close()
+}
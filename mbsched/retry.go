@@ -0,0 +1,138 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package mbsched
+
+import(
+	"errors"
+	"github.com/goburrow/modbus"
+	"math"
+	"time"
+)
+
+// Retryable classifies whether a failed command execution should be
+// retried within the same call to command.Execute, as decided by a
+// RetryPolicy's Classifier.
+type Retryable int
+
+const(
+	// Retry indicates the error is transient, such as a timeout, an
+	// RTU CRC mismatch, or a dropped TCP connection, and the command
+	// should be retried.
+	Retry Retryable = iota
+
+	// NoRetry indicates the error is permanent, such as an illegal
+	// function or illegal data address exception, and retrying would
+	// only repeat it.
+	NoRetry
+)
+
+// RetryPolicy configures command.Execute's exponential backoff retry
+// loop for transient modbus errors, in the spirit of the cenkalti/backoff
+// pattern. The zero value disables retries: MaxRetries is 0, so Execute
+// behaves exactly as it did before this policy existed, returning the
+// first error it sees.
+type RetryPolicy struct {
+	// InitialInterval is the backoff duration before the first retry.
+	InitialInterval time.Duration
+
+	// MaxInterval caps the backoff duration after Multiplier growth.
+	// Zero means the backoff is uncapped.
+	MaxInterval time.Duration
+
+	// MaxElapsedTime bounds the total time spent retrying, measured
+	// from the first attempt. Zero means only MaxRetries bounds the
+	// loop.
+	MaxElapsedTime time.Duration
+
+	// Multiplier scales the backoff duration after each retry. Values
+	// less than 1 are treated as 1, so every retry waits
+	// InitialInterval.
+	Multiplier float64
+
+	// MaxRetries is the number of retries attempted after the first
+	// failure before Execute gives up. Zero disables the policy.
+	MaxRetries int
+
+	// Classifier decides whether a given error should be retried. A
+	// nil Classifier defaults to DefaultClassifier.
+	Classifier func( error ) Retryable
+}
+
+// classify reports whether err should be retried, applying p.Classifier
+// or DefaultClassifier if p.Classifier is nil.
+func ( p RetryPolicy ) classify( err error ) Retryable {
+	if p.Classifier != nil {
+		return p.Classifier( err )
+	}
+
+	return DefaultClassifier( err )
+}
+
+// backoff computes the delay before retry number attempt (1 for the
+// first retry), applying Multiplier growth capped at MaxInterval.
+func ( p RetryPolicy ) backoff( attempt int ) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64( p.InitialInterval ) * math.Pow( multiplier, float64( attempt - 1 ) )
+	if max := float64( p.MaxInterval ); max > 0 && d > max {
+		d = max
+	}
+
+	return time.Duration( d )
+}
+
+// DefaultClassifier retries everything except a *modbus.ModbusError
+// exception indicating the request itself was malformed (illegal
+// function, illegal data address, illegal data value), which a retry
+// would only repeat verbatim. Timeouts, RTU CRC errors, and dropped TCP
+// connections surface as plain errors from the underlying connection,
+// not as a ModbusError, so they fall through to the retryable default.
+func DefaultClassifier( err error ) Retryable {
+	var modbusErr *modbus.ModbusError
+	if errors.As( err, &modbusErr ) {
+		switch modbusErr.ExceptionCode {
+		case modbus.ExceptionCodeIllegalFunction, modbus.ExceptionCodeIllegalDataAddress, modbus.ExceptionCodeIllegalDataValue:
+			return NoRetry
+		}
+	}
+
+	return Retry
+}
+
+// RetryReport describes the outcome of one command.Execute call which
+// needed at least one retry, so callers can distinguish a command which
+// eventually succeeded from one which exhausted its RetryPolicy.
+type RetryReport struct {
+	// Name is the command's name, as registered with the scheduler.
+	Name string
+
+	// Attempts is the total number of attempts made, including the
+	// first.
+	Attempts int
+
+	// Err is nil if the command eventually succeeded, or the last
+	// error seen if it exhausted its RetryPolicy.
+	Err error
+}
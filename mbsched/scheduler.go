@@ -33,89 +33,168 @@ import(
 type Scheduler struct {
 	sched.Scheduler
 
-	// handler is the modbus handler
-	handler handler
+	// pool dispatches commands to one or more underlying connections.
+	pool Pool
+
+	// plugins holds the vendor function code plugins registered via
+	// UsePlugins. It is nil unless UsePlugins has been called.
+	plugins *PluginRegistry
+
+	// retryReportChan is the channel every AddXxx command reports its
+	// RetryReports to. It is set by Start, so it is nil before the
+	// scheduler has been started.
+	retryReportChan chan RetryReport
+}
+
+// UsePlugins registers the plugin subprocesses AddPluginCommand may
+// dispatch to. It must be called before AddPluginCommand.
+func ( s *Scheduler ) UsePlugins( registry *PluginRegistry ) {
+	s.plugins = registry
+}
+
+// AddPluginCommand adds a command implemented by the named plugin to a
+// running scheduler. pluginName must have been loaded into the
+// registry passed to UsePlugins. args is an opaque blob the plugin
+// interprets to build the request PDU.
+// retry configures the command's in-call retry behaviour; the zero
+// value disables retries.
+// On success, it returns a channel with buffer size bufSize
+// yielding the plugin-decoded result of each execution.
+func ( s *Scheduler ) AddPluginCommand( name string, schedule sched.Schedule, bufSize int, slaveId byte, pluginName string, args []byte, retry RetryPolicy ) ( <-chan []byte, error ) {
+	if s.plugins == nil {
+		return nil, fmt.Errorf( "No plugin registry configured; call UsePlugins first" )
+	}
+	client, ok := s.plugins.Get( pluginName )
+	if !ok {
+		return nil, fmt.Errorf( "Unknown plugin '%s'", pluginName )
+	}
+	command, resultChan := newPluginCommand( bufSize, s.pool, client, slaveId, args, name, retry, s.retryReportChan )
+	err := s.Scheduler.Add( name, command, schedule )
+
+	return resultChan, err
 }
 
 // NewModbusAsciiScheduler creates a new modbus ASCII scheduler.
+// As ASCII is a serial bus, commands are serialized through a single
+// connection, but dispatched via a Pool so a slow response does not
+// block the scheduler's dispatch loop.
 func NewModbusAsciiScheduler( scheduleBufferSize int, addr string, baudRate int, dataBits int, parity string, stopBits int, timeout time.Duration ) *Scheduler {
 	return &Scheduler{
 		Scheduler: *sched.NewScheduler( scheduleBufferSize ),
-		handler: newAsciiHandler( addr, baudRate, dataBits, parity, stopBits, timeout ),
+		pool: &lazySerialPool{ handler: newAsciiHandler( addr, baudRate, dataBits, parity, stopBits, timeout ) },
 	}
 }
 
 // NewModbusRtuScheduler creates a new modbus RTU scheduler.
+// As RTU is a serial bus, commands are serialized through a single
+// connection, but dispatched via a Pool so a slow response does not
+// block the scheduler's dispatch loop.
 func NewModbusRtuScheduler( scheduleBufferSize int, addr string, baudRate int, dataBits int, parity string, stopBits int, timeout time.Duration ) *Scheduler {
 	return &Scheduler{
 		Scheduler: *sched.NewScheduler( scheduleBufferSize ),
-		handler: newRtuHandler( addr, baudRate, dataBits, parity, stopBits, timeout ),
+		pool: &lazySerialPool{ handler: newRtuHandler( addr, baudRate, dataBits, parity, stopBits, timeout ) },
 	}
 }
 
-// NewModbusTcpScheduler creates a new modbus TCP scheduler.
-func NewModbusTcpScheduler( scheduleBufferSize int, addr string, timeout time.Duration ) *Scheduler {
+// NewModbusTcpScheduler creates a new modbus TCP scheduler backed by a
+// pool of poolSize parallel TCP connections, so commands targeting
+// different slaves can execute concurrently instead of serializing
+// through a single socket. poolSize must be positive.
+func NewModbusTcpScheduler( scheduleBufferSize int, addr string, poolSize int, timeout time.Duration ) *Scheduler {
 	return &Scheduler{
 		Scheduler: *sched.NewScheduler( scheduleBufferSize ),
-		handler: newTcpHandler( addr, timeout ),
+		pool: &lazyTcpPool{ addr: addr, size: poolSize, timeout: timeout },
 	}
 }
 
+// RemoveSchedule removes the named command's schedule from the
+// scheduler, stopping any further executions. The command's result
+// channel is closed once the scheduler's dispatch loop notices the
+// removal, so a goroutine draining that channel terminates on its own.
+func ( s *Scheduler ) RemoveSchedule( name string ) error {
+	return s.Scheduler.Remove( name )
+}
+
 // AddReadInputRegisters adds a modbus read input registers command
 // to a running scheduler.
+// retry configures the command's in-call retry behaviour; the zero
+// value disables retries.
 // On success, it returns a channel with buffer size bufSize
 // yielding the read data.
-func ( s *Scheduler ) AddReadInputRegisters( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, quantity uint16 ) ( <-chan []byte, error ) {
-	command, resultChan := newReadInputRegisters( bufSize, s.handler, slaveId, address, quantity )
+func ( s *Scheduler ) AddReadInputRegisters( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, quantity uint16, retry RetryPolicy ) ( <-chan []byte, error ) {
+	command, resultChan := newReadInputRegisters( bufSize, s.pool, slaveId, address, quantity, name, retry, s.retryReportChan )
 	err := s.Scheduler.Add( name, command, schedule )
 
 	return resultChan, err
 }
 
 // Start starts the scheduler.
-// A channel reporting scheduler errors is returned.
-// The buffer size of this channel is given by error backlog.
-// On success, the second return value is nil.
+// A channel reporting scheduler errors, and a channel reporting
+// RetryReports for commands whose Execute needed more than one
+// attempt, are returned. The buffer size of both channels is given by
+// errorBacklog.
+// On success, the third return value is nil.
 // Otherwise, it is an appropriate error message.
-func ( s *Scheduler ) Start( errorBacklog int ) ( <-chan error, error ) {
-	err := s.handler.Connect()
+func ( s *Scheduler ) Start( errorBacklog int ) ( <-chan error, <-chan RetryReport, error ) {
+	err := s.pool.( poolOpener ).open()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	result, err := s.Scheduler.Start( errorBacklog )
 	if err != nil {
-		err2 := s.handler.Close()
+		err2 := s.pool.Close()
 		if err2 != nil {
-			err = fmt.Errorf( "Error '%v' starting scheduler followed by error '%v' closing handler", err, err2 )
+			err = fmt.Errorf( "Error '%v' starting scheduler followed by error '%v' closing pool", err, err2 )
 		}
+
+		return nil, nil, err
 	}
+	retryReportChan := make( chan RetryReport, errorBacklog )
+	s.retryReportChan = retryReportChan
 
-	return result, err
+	return result, retryReportChan, nil
 }
 
 // WaitStop waits for the scheduler to stop
 // after a call to SignalStop.
 // Without a call to SignalStop,
 // WaitStop will wait forever.
-func ( s *Scheduler ) WaitStop() {
-	s.Scheduler.WaitStop()
-	s.handler.Close()
+// If the scheduler is not running, e.g. because WaitStop already ran to
+// completion on a previous call, or the scheduler was never started,
+// WaitStop returns the embedded Scheduler's error without touching pool
+// or retryReportChan again.
+func ( s *Scheduler ) WaitStop() error {
+	err := s.Scheduler.WaitStop()
+	if err != nil {
+		return err
+	}
+	if err2 := s.pool.Close(); err2 != nil {
+		err = err2
+	}
+	close( s.retryReportChan )
+
+	return err
 }
 
 // Stop stops the scheduler.
 // It combines SignalStop and WaitStop into one method.
-func ( s *Scheduler ) Stop() {
-	s.SignalStop()
-	s.WaitStop()
+func ( s *Scheduler ) Stop() error {
+	if err := s.SignalStop(); err != nil {
+		return err
+	}
+
+	return s.WaitStop()
 }
 
 // AddReadHoldingRegisters adds a modbus read holding registers command
 // to a running scheduler.
+// retry configures the command's in-call retry behaviour; the zero
+// value disables retries.
 // On success, it returns a channel with buffer size bufSize
 // yielding the read data.
-func ( s *Scheduler ) AddReadHoldingRegisters( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, quantity uint16 ) ( <-chan []byte, error ) {
-	command, resultChan := newReadHoldingRegisters( bufSize, s.handler, slaveId, address, quantity )
+func ( s *Scheduler ) AddReadHoldingRegisters( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, quantity uint16, retry RetryPolicy ) ( <-chan []byte, error ) {
+	command, resultChan := newReadHoldingRegisters( bufSize, s.pool, slaveId, address, quantity, name, retry, s.retryReportChan )
 	err := s.Scheduler.Add( name, command, schedule )
 
 	return resultChan, err
@@ -123,10 +202,12 @@ func ( s *Scheduler ) AddReadHoldingRegisters( name string, schedule sched.Sched
 
 // AddWriteSingleRegister adds a modbus write single register command
 // to a running scheduler.
+// retry configures the command's in-call retry behaviour; the zero
+// value disables retries.
 // On success, it returns a channel with buffer size bufSize
 // yielding the value written.
-func ( s *Scheduler ) AddWriteSingleRegister( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, value uint16 ) ( <-chan []byte, error ) {
-	command, resultChan := newWriteSingleRegister( bufSize, s.handler, slaveId, address, value )
+func ( s *Scheduler ) AddWriteSingleRegister( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, value uint16, retry RetryPolicy ) ( <-chan []byte, error ) {
+	command, resultChan := newWriteSingleRegister( bufSize, s.pool, slaveId, address, value, name, retry, s.retryReportChan )
 	err := s.Scheduler.Add( name, command, schedule )
 
 	return resultChan, err
@@ -134,10 +215,64 @@ func ( s *Scheduler ) AddWriteSingleRegister( name string, schedule sched.Schedu
 
 // AddWriteMultipleRegisters adds a modbus write multiple registers command
 // to a running scheduler.
+// retry configures the command's in-call retry behaviour; the zero
+// value disables retries.
 // On success, it returns a channel with buffer size bufSize
 // yielding the quantity of values written.
-func ( s *Scheduler ) AddWriteMultipleRegisters( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, quantity uint16, values []byte ) ( <-chan []byte, error ) {
-	command, resultChan := newWriteMultipleRegisters( bufSize, s.handler, slaveId, address, quantity, values )
+func ( s *Scheduler ) AddWriteMultipleRegisters( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, quantity uint16, values []byte, retry RetryPolicy ) ( <-chan []byte, error ) {
+	command, resultChan := newWriteMultipleRegisters( bufSize, s.pool, slaveId, address, quantity, values, name, retry, s.retryReportChan )
+	err := s.Scheduler.Add( name, command, schedule )
+
+	return resultChan, err
+}
+
+// AddReadCoils adds a modbus read coils command to a running scheduler.
+// retry configures the command's in-call retry behaviour; the zero
+// value disables retries.
+// On success, it returns a channel with buffer size bufSize
+// yielding the coil states, packed one bit per coil.
+func ( s *Scheduler ) AddReadCoils( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, quantity uint16, retry RetryPolicy ) ( <-chan []byte, error ) {
+	command, resultChan := newReadCoils( bufSize, s.pool, slaveId, address, quantity, name, retry, s.retryReportChan )
+	err := s.Scheduler.Add( name, command, schedule )
+
+	return resultChan, err
+}
+
+// AddReadDiscreteInputs adds a modbus read discrete inputs command to a
+// running scheduler.
+// retry configures the command's in-call retry behaviour; the zero
+// value disables retries.
+// On success, it returns a channel with buffer size bufSize
+// yielding the input states, packed one bit per input.
+func ( s *Scheduler ) AddReadDiscreteInputs( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, quantity uint16, retry RetryPolicy ) ( <-chan []byte, error ) {
+	command, resultChan := newReadDiscreteInputs( bufSize, s.pool, slaveId, address, quantity, name, retry, s.retryReportChan )
+	err := s.Scheduler.Add( name, command, schedule )
+
+	return resultChan, err
+}
+
+// AddWriteSingleCoil adds a modbus write single coil command to a
+// running scheduler. value must be either 0x0000 (off) or 0xFF00 (on).
+// retry configures the command's in-call retry behaviour; the zero
+// value disables retries.
+// On success, it returns a channel with buffer size bufSize
+// yielding the value written.
+func ( s *Scheduler ) AddWriteSingleCoil( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, value uint16, retry RetryPolicy ) ( <-chan []byte, error ) {
+	command, resultChan := newWriteSingleCoil( bufSize, s.pool, slaveId, address, value, name, retry, s.retryReportChan )
+	err := s.Scheduler.Add( name, command, schedule )
+
+	return resultChan, err
+}
+
+// AddWriteMultipleCoils adds a modbus write multiple coils command to a
+// running scheduler. values must hold the coil states packed one bit
+// per coil, i.e. exactly (quantity+7)/8 bytes.
+// retry configures the command's in-call retry behaviour; the zero
+// value disables retries.
+// On success, it returns a channel with buffer size bufSize
+// yielding the quantity of coils written.
+func ( s *Scheduler ) AddWriteMultipleCoils( name string, schedule sched.Schedule, bufSize int, slaveId byte, address uint16, quantity uint16, values []byte, retry RetryPolicy ) ( <-chan []byte, error ) {
+	command, resultChan := newWriteMultipleCoils( bufSize, s.pool, slaveId, address, quantity, values, name, retry, s.retryReportChan )
 	err := s.Scheduler.Add( name, command, schedule )
 
 	return resultChan, err
@@ -0,0 +1,230 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package sched
+
+import(
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField describes which values of a single cron field are matched.
+type cronField struct {
+	// allowed maps an allowed value to true.
+	allowed map[int]bool
+
+	// restricted is true if the field was anything other than '*' in
+	// the original spec. It drives the day-of-month/day-of-week union
+	// rule in CronTrigger.dayMatches.
+	restricted bool
+}
+
+// matches checks whether v is among the allowed values of the field.
+func ( f cronField ) matches( v int ) bool {
+	return f.allowed[v]
+}
+
+// parseCronField parses a single cron field with the given inclusive bounds.
+// It supports '*', comma-separated lists, ranges ('a-b') and steps ('.../n'),
+// which may be combined, e.g. "1-10/2,15,20-25".
+func parseCronField( field string, min int, max int ) ( cronField, error ) {
+	result := cronField{
+		allowed: make( map[int]bool ),
+		restricted: field != "*",
+	}
+	for _, part := range strings.Split( field, "," ) {
+		rangeAndStep := strings.SplitN( part, "/", 2 )
+		rangePart := rangeAndStep[0]
+		step := 1
+		if len( rangeAndStep ) == 2 {
+			s, err := strconv.Atoi( rangeAndStep[1] )
+			if err != nil || s <= 0 {
+				return cronField{}, fmt.Errorf( "invalid step '%s' in cron field '%s'", rangeAndStep[1], field )
+			}
+			step = s
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN( rangePart, "-", 2 )
+			l, err := strconv.Atoi( bounds[0] )
+			if err != nil {
+				return cronField{}, fmt.Errorf( "invalid value '%s' in cron field '%s'", bounds[0], field )
+			}
+			lo = l
+			hi = l
+			if len( bounds ) == 2 {
+				h, err := strconv.Atoi( bounds[1] )
+				if err != nil {
+					return cronField{}, fmt.Errorf( "invalid value '%s' in cron field '%s'", bounds[1], field )
+				}
+				hi = h
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return cronField{}, fmt.Errorf( "cron field '%s' out of bounds [%d, %d]", field, min, max )
+		}
+
+		for v := lo; v <= hi; v += step {
+			result.allowed[v] = true
+		}
+	}
+
+	return result, nil
+}
+
+// CronTrigger is a parsed cron expression, reusable across successive
+// NextAfter calls to compute wall-clock fire times. It accepts the
+// standard 5-field form, "minute hour day-of-month month day-of-week",
+// or a 6-field form with a leading seconds field, "second minute hour
+// day-of-month month day-of-week", for schedules that need sub-minute
+// resolution. Each field may be '*', a single value, a comma-separated
+// list, a range "a-b", or a stepped range "a-b/n". Day-of-week runs from
+// 0 (Sunday) to 6 (Saturday).
+type CronTrigger struct {
+	second cronField
+	minute cronField
+	hour cronField
+	dom cronField
+	month cronField
+	dow cronField
+}
+
+// ParseCronTrigger parses a 5- or 6-field cron expression into a
+// CronTrigger.
+func ParseCronTrigger( spec string ) ( *CronTrigger, error ) {
+	fields := strings.Fields( spec )
+	secondField := "0"
+	switch len( fields ) {
+	case 5:
+		// second defaults to "0", i.e. minute resolution
+	case 6:
+		secondField = fields[0]
+		fields = fields[1:]
+	default:
+		return nil, fmt.Errorf( "cron spec '%s' must have 5 or 6 fields, got %d", spec, len( fields ) )
+	}
+
+	second, err := parseCronField( secondField, 0, 59 )
+	if err != nil {
+		return nil, fmt.Errorf( "second: %v", err )
+	}
+	minute, err := parseCronField( fields[0], 0, 59 )
+	if err != nil {
+		return nil, fmt.Errorf( "minute: %v", err )
+	}
+	hour, err := parseCronField( fields[1], 0, 23 )
+	if err != nil {
+		return nil, fmt.Errorf( "hour: %v", err )
+	}
+	dom, err := parseCronField( fields[2], 1, 31 )
+	if err != nil {
+		return nil, fmt.Errorf( "day-of-month: %v", err )
+	}
+	month, err := parseCronField( fields[3], 1, 12 )
+	if err != nil {
+		return nil, fmt.Errorf( "month: %v", err )
+	}
+	dow, err := parseCronField( fields[4], 0, 6 )
+	if err != nil {
+		return nil, fmt.Errorf( "day-of-week: %v", err )
+	}
+
+	return &CronTrigger{
+		second: second,
+		minute: minute,
+		hour: hour,
+		dom: dom,
+		month: month,
+		dow: dow,
+	}, nil
+}
+
+// dayMatches reports whether t's calendar day matches c's day-of-month
+// and day-of-week fields. Per standard cron semantics, if both fields
+// are restricted (not '*'), a day matching either one is enough; if at
+// most one is restricted, that one (or neither) must match.
+func ( c *CronTrigger ) dayMatches( t time.Time ) bool {
+	domMatch := c.dom.matches( t.Day() )
+	dowMatch := c.dow.matches( int( t.Weekday() ) )
+	if c.dom.restricted && c.dow.restricted {
+		return domMatch || dowMatch
+	}
+
+	return domMatch && dowMatch
+}
+
+// NextAfter returns the earliest instant strictly after t which matches
+// c, advancing field by field from most significant (month) to least
+// significant (second) with carry: a field that does not match rolls
+// its candidate forward to the start of the next value of that field,
+// zeroing every field below it, rather than scanning one tick at a
+// time. t's location is preserved, so DST transitions are handled the
+// way time.Time normally handles them.
+func ( c *CronTrigger ) NextAfter( t time.Time ) time.Time {
+	t = t.Truncate( time.Second ).Add( time.Second )
+	// Five years is more than enough lookahead to find a match or give
+	// up, even for day-of-month/month combinations that only recur on
+	// leap years (e.g. "0 0 29 2 *").
+	limit := t.AddDate( 5, 0, 0 )
+	for t.Before( limit ) {
+		if !c.month.matches( int( t.Month() ) ) {
+			y, m, _ := t.Date()
+			t = time.Date( y, m + 1, 1, 0, 0, 0, 0, t.Location() )
+			continue
+		}
+		if !c.dayMatches( t ) {
+			y, m, d := t.Date()
+			t = time.Date( y, m, d + 1, 0, 0, 0, 0, t.Location() )
+			continue
+		}
+		if !c.hour.matches( t.Hour() ) {
+			y, m, d := t.Date()
+			t = time.Date( y, m, d, t.Hour() + 1, 0, 0, 0, t.Location() )
+			continue
+		}
+		if !c.minute.matches( t.Minute() ) {
+			y, m, d := t.Date()
+			t = time.Date( y, m, d, t.Hour(), t.Minute() + 1, 0, 0, t.Location() )
+			continue
+		}
+		if !c.second.matches( t.Second() ) {
+			y, m, d := t.Date()
+			t = time.Date( y, m, d, t.Hour(), t.Minute(), t.Second() + 1, 0, t.Location() )
+			continue
+		}
+
+		return t
+	}
+
+	// No match found within the lookahead window; this should not
+	// happen for any sane cron spec. Fall back to the lookahead limit.
+	return limit
+}
+
+// Next implements Trigger by delegating to NextAfter, so a CronTrigger
+// can be used directly as Schedule.Trigger.
+func ( c *CronTrigger ) Next( prev time.Time ) time.Time {
+	return c.NextAfter( prev )
+}
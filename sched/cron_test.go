@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package sched
+
+import(
+	"testing"
+	"time"
+)
+
+func TestParseCronSpecBad( t *testing.T ) {
+	bad := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 7",
+		"abc * * * *",
+	}
+	for _, spec := range bad {
+		if _, err := ParseCronTrigger( spec ); err == nil {
+			t.Errorf( "Expected error parsing cron spec '%s'", spec )
+		}
+	}
+}
+
+func TestCronNextEveryWeekdayAt8( t *testing.T ) {
+	cron, err := ParseCronTrigger( "0 8 * * 1-5" )
+	if err != nil {
+		t.Fatalf( "Unable to parse cron spec: %v", err )
+	}
+
+	// 2026-07-25 is a Saturday; the next weekday 08:00 is Monday 2026-07-27.
+	from := time.Date( 2026, time.July, 25, 12, 0, 0, 0, time.UTC )
+	next := cron.NextAfter( from )
+	want := time.Date( 2026, time.July, 27, 8, 0, 0, 0, time.UTC )
+	if !next.Equal( want ) {
+		t.Errorf( "Got next fire time %v, want %v", next, want )
+	}
+}
+
+func TestCronNextWithSeconds( t *testing.T ) {
+	cron, err := ParseCronTrigger( "*/15 * * * * *" )
+	if err != nil {
+		t.Fatalf( "Unable to parse cron spec: %v", err )
+	}
+
+	from := time.Date( 2026, time.July, 25, 12, 0, 1, 0, time.UTC )
+	next := cron.NextAfter( from )
+	want := time.Date( 2026, time.July, 25, 12, 0, 15, 0, time.UTC )
+	if !next.Equal( want ) {
+		t.Errorf( "Got next fire time %v, want %v", next, want )
+	}
+}
+
+func TestCronDayOfMonthDayOfWeekUnion( t *testing.T ) {
+	// Both day-of-month and day-of-week restricted: a day matches if it
+	// satisfies either one.
+	cron, err := ParseCronTrigger( "0 0 1 * 1" )
+	if err != nil {
+		t.Fatalf( "Unable to parse cron spec: %v", err )
+	}
+
+	// 2026-07-25 is a Saturday; 2026-07-27 is the next Monday, which
+	// fires before day 1 of August.
+	from := time.Date( 2026, time.July, 25, 12, 0, 0, 0, time.UTC )
+	next := cron.NextAfter( from )
+	want := time.Date( 2026, time.July, 27, 0, 0, 0, 0, time.UTC )
+	if !next.Equal( want ) {
+		t.Errorf( "Got next fire time %v, want %v", next, want )
+	}
+}
+
+func TestIntervalTrigger( t *testing.T ) {
+	trigger := IntervalTrigger( 30 * time.Second )
+	from := time.Date( 2026, time.July, 25, 12, 0, 0, 0, time.UTC )
+	next := trigger.Next( from )
+	want := from.Add( 30 * time.Second )
+	if !next.Equal( want ) {
+		t.Errorf( "Got next fire time %v, want %v", next, want )
+	}
+}
+
+func TestScheduleCronTrigger( t *testing.T ) {
+	s := &Schedule{
+		CronSpec: "* * * * *",
+	}
+	if !s.isCron() {
+		t.Error( "Schedule with CronSpec set should report isCron() == true" )
+	}
+	if err := s.parseCron(); err != nil {
+		t.Fatalf( "Unable to parse cron spec: %v", err )
+	}
+	before := monotonicNow()
+	trigger := s.nextCronTrigger( before )
+	if !before.Before( trigger ) {
+		t.Error( "Cron trigger should lie in the future" )
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package sched
+
+import(
+	"time"
+)
+
+// ServingStatus mirrors the three-valued status of the gRPC health
+// checking protocol, applied here to a single scheduled command.
+type ServingStatus int
+
+const(
+	// StatusUnknown indicates the command has not completed an
+	// execution yet, successful or not.
+	StatusUnknown ServingStatus = iota
+
+	// StatusServing indicates the command's most recent execution
+	// succeeded.
+	StatusServing
+
+	// StatusNotServing indicates the command's most recent execution
+	// failed.
+	StatusNotServing
+)
+
+// String returns the gRPC health checking protocol's name for status.
+func ( status ServingStatus ) String() string {
+	switch status {
+	case StatusServing:
+		return "SERVING"
+	case StatusNotServing:
+		return "NOT_SERVING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// CommandStatus is a snapshot of one named command's execution health,
+// as returned by Scheduler.Status.
+type CommandStatus struct {
+	// Status is the command's current serving status.
+	Status ServingStatus
+
+	// LastSuccess is the wall-clock time of the most recent successful
+	// execution, or the zero Time if it has never succeeded.
+	LastSuccess time.Time
+
+	// LastFailure is the wall-clock time of the most recent failed
+	// execution, or the zero Time if it has never failed.
+	LastFailure time.Time
+
+	// LastErr is the error returned by the most recent failed
+	// execution, or nil if the command has never failed or its most
+	// recent execution succeeded.
+	LastErr error
+
+	// ConsecutiveFailures is the number of consecutive failed
+	// executions up to and including LastFailure. It is reset to zero
+	// by a successful execution.
+	ConsecutiveFailures int
+}
+
+// statusSnapshot reads out s's status fields under statusMutex.
+func ( s *Schedule ) statusSnapshot() CommandStatus {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	status := StatusUnknown
+	switch {
+	case s.lastSuccess.IsZero() && s.lastFailure.IsZero():
+		status = StatusUnknown
+	case s.attempt > 0:
+		status = StatusNotServing
+	default:
+		status = StatusServing
+	}
+
+	return CommandStatus{
+		Status: status,
+		LastSuccess: s.lastSuccess,
+		LastFailure: s.lastFailure,
+		LastErr: s.lastErr,
+		ConsecutiveFailures: s.attempt,
+	}
+}
+
+// Status reports the current health of the named command. ok is false
+// if no command with that name is currently scheduled.
+func ( s *Scheduler ) Status( name string ) ( status CommandStatus, ok bool ) {
+	s.mapMutex.Lock()
+	schedule, ok := s.scheduleMap[name]
+	s.mapMutex.Unlock()
+	if !ok {
+		return CommandStatus{}, false
+	}
+
+	return schedule.statusSnapshot(), true
+}
+
+// Statuses reports the current health of every currently scheduled
+// command, keyed by name.
+func ( s *Scheduler ) Statuses() map[string]CommandStatus {
+	s.mapMutex.Lock()
+	schedules := make( map[string]*Schedule, len( s.scheduleMap ) )
+	for name, schedule := range s.scheduleMap {
+		schedules[name] = schedule
+	}
+	s.mapMutex.Unlock()
+	result := make( map[string]CommandStatus, len( schedules ) )
+	for name, schedule := range schedules {
+		result[name] = schedule.statusSnapshot()
+	}
+
+	return result
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package sched
+
+import(
+	"fmt"
+	"github.com/TheCount/goodbus/sched/store"
+)
+
+// CommandFactory reconstructs a Command from the Descriptor of a
+// persisted Schedule, so a Scheduler can restore schedules added before
+// a restart. It is called once per persisted schedule during Start.
+type CommandFactory func( descriptor map[string]interface{} ) ( Command, error )
+
+// UseStore configures the Store a Scheduler persists schedules to, and
+// the CommandFactory used to reconstruct their Commands when restoring
+// schedules on Start. UseStore must be called before Start; it is not
+// goroutine-safe with respect to Add, Remove or Start.
+func ( s *Scheduler ) UseStore( st store.Store, factory CommandFactory ) {
+	s.store = st
+	s.commandFactory = factory
+}
+
+// toPersisted converts the given named schedule into its persisted
+// representation.
+func toPersisted( name string, schedule Schedule ) store.PersistedSchedule {
+	return store.PersistedSchedule{
+		Name: name,
+		Flags: schedule.Flags,
+		MinWait: schedule.MinWait,
+		MaxWait: schedule.MaxWait,
+		CronSpec: schedule.CronSpec,
+		Descriptor: schedule.Descriptor,
+	}
+}
+
+// restoreSchedules loads all persisted schedules from the configured
+// Store and re-adds them to the scheduler via commandFactory. It is a
+// no-op if commandFactory is nil, i.e. UseStore has not been called.
+func ( s *Scheduler ) restoreSchedules() error {
+	if s.commandFactory == nil {
+		return nil
+	}
+	persisted, err := s.store.LoadAll()
+	if err != nil {
+		return fmt.Errorf( "Unable to load persisted schedules: %v", err )
+	}
+	for _, p := range persisted {
+		command, err := s.commandFactory( p.Descriptor )
+		if err != nil {
+			return fmt.Errorf( "Unable to reconstruct command for persisted schedule '%s': %v", p.Name, err )
+		}
+		schedule := Schedule{
+			Flags: p.Flags,
+			MinWait: p.MinWait,
+			MaxWait: p.MaxWait,
+			CronSpec: p.CronSpec,
+			Descriptor: p.Descriptor,
+		}
+		if err := s.add( p.Name, command, schedule, false ); err != nil {
+			return fmt.Errorf( "Unable to restore persisted schedule '%s': %v", p.Name, err )
+		}
+	}
+
+	return nil
+}
@@ -39,15 +39,21 @@ func ( s scheduleStack ) Less( i, j int ) bool {
 	return s[i].triggerTime.Before( s[j].triggerTime )
 }
 
-// Swap exchanges two elements in the scheduleStack
+// Swap exchanges two elements in the scheduleStack, keeping each
+// schedule's index field in sync with its new position so Remove can
+// find it again.
 func ( s scheduleStack ) Swap( i, j int ) {
 	s[i], s[j] = s[j], s[i]
+	s[i].index = i
+	s[j].index = j
 }
 
 // Push adds an element to the scheduleStack.
 // If x is not a pointer to a Schedule, Push() panics.
 func ( s *scheduleStack ) Push( x interface{} ) {
-	*s = append( *s, x.( *Schedule ) )
+	schedule := x.( *Schedule )
+	schedule.index = len( *s )
+	*s = append( *s, schedule )
 }
 
 // Pop removes an element from the scheduleStack.
@@ -57,6 +63,7 @@ func ( s *scheduleStack ) Pop() interface{} {
 	oldstack := *s
 	index := len( oldstack ) - 1
 	x := oldstack[index]
+	x.index = -1
 	*s = oldstack[0 : index]
 	return x
 }
@@ -81,6 +88,21 @@ func ( sq *ScheduleQueue ) Pop() *Schedule {
 	return heap.Pop( &sq.scheduleStack ).( *Schedule )
 }
 
+// Remove evicts schedule from the priority queue immediately, instead
+// of waiting for it to reach the front of the heap. It reports whether
+// schedule was found in the queue; a schedule which has already been
+// popped, or which belongs to a different queue, is reported as not
+// found rather than removing the wrong element.
+func ( sq *ScheduleQueue ) Remove( schedule *Schedule ) bool {
+	index := schedule.index
+	if index < 0 || index >= len( sq.scheduleStack ) || sq.scheduleStack[index] != schedule {
+		return false
+	}
+	heap.Remove( &sq.scheduleStack, index )
+
+	return true
+}
+
 // Peek returns the minimal element from the priority queue
 // without removing it.
 // If the queue is empty, nil is returned.
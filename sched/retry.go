@@ -0,0 +1,114 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package sched
+
+import(
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Retry configures a per-schedule retry policy with exponential backoff
+// and jitter, applied when Command.Execute returns an error. The zero
+// value of Retry (MaxAttempts == 0) disables the policy, leaving the
+// pre-existing ScheduleRemoveOnError behaviour in effect after the very
+// first failure.
+type Retry struct {
+	// MaxAttempts is the number of consecutive failures tolerated
+	// before the schedule is removed (if ScheduleRemoveOnError is set)
+	// or simply falls back to its regular MinWait/MaxWait or cron
+	// cadence. The attempt counter is reset to zero on success.
+	MaxAttempts int
+
+	// InitialBackoff is the backoff duration applied after the first
+	// consecutive failure.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the computed backoff duration. A zero value
+	// means the backoff is uncapped.
+	MaxBackoff time.Duration
+
+	// Multiplier scales the backoff duration after each consecutive
+	// failure. Values <= 1 disable growth, so every retry uses
+	// InitialBackoff.
+	Multiplier float64
+
+	// Jitter is the fraction (0 to 1) of the computed backoff duration
+	// by which the actual backoff may randomly deviate in either
+	// direction, to avoid thundering-herd retries across many
+	// schedules.
+	Jitter float64
+}
+
+// RetryError wraps a command execution error together with retry
+// bookkeeping, so callers reading errChan can log or make decisions
+// based on how many consecutive attempts have failed and when the
+// schedule will retry next.
+type RetryError struct {
+	// Err is the underlying error returned by Command.Execute.
+	Err error
+
+	// Attempt is the number of consecutive failures, including this one.
+	Attempt int
+
+	// NextBackoff is the backoff duration before the next retry, or
+	// zero if the schedule's regular MinWait/MaxWait/cron cadence
+	// applies instead, e.g. because no Retry policy is configured, or
+	// MaxAttempts has been reached.
+	NextBackoff time.Duration
+}
+
+// Error implements the error interface.
+func ( e *RetryError ) Error() string {
+	return fmt.Sprintf( "attempt %d failed (next backoff %v): %v", e.Attempt, e.NextBackoff, e.Err )
+}
+
+// Unwrap allows errors.Is/errors.As to reach the underlying error.
+func ( e *RetryError ) Unwrap() error {
+	return e.Err
+}
+
+// nextBackoff computes the backoff duration for the given consecutive
+// failure count (attempt is 1 for the first failure), applying
+// Multiplier growth capped at MaxBackoff and randomised by Jitter.
+func ( s *Schedule ) nextBackoff( attempt int ) time.Duration {
+	backoff := float64( s.Retry.InitialBackoff )
+	multiplier := s.Retry.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff *= math.Pow( multiplier, float64( attempt - 1 ) )
+	if maxBackoff := float64( s.Retry.MaxBackoff ); maxBackoff > 0 && backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	if s.Retry.Jitter > 0 {
+		deviation := ( rand.Float64()*2 - 1 ) * s.Retry.Jitter
+		backoff *= 1 + deviation
+		if backoff < 0 {
+			backoff = 0
+		}
+	}
+
+	return time.Duration( backoff )
+}
@@ -23,6 +23,7 @@ SOFTWARE.
 package sched
 
 import(
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -52,6 +53,26 @@ const(
 	scheduleRemoved
 )
 
+// Trigger computes wall-clock fire times for a schedule driven by
+// something other than a plain MinWait/MaxWait wait window. Next is
+// called with the wall-clock time of the previous fire (or, for the
+// first call, roughly the current time) and returns the next one. A
+// fixed-interval schedule is the trivial Trigger IntervalTrigger(d),
+// which returns prev.Add(d); CronTrigger, parsed from a cron expression,
+// is the calendar-driven one.
+type Trigger interface {
+	Next( prev time.Time ) time.Time
+}
+
+// IntervalTrigger is the trivial Trigger that fires every fixed
+// duration after its previous invocation.
+type IntervalTrigger time.Duration
+
+// Next implements Trigger.
+func ( d IntervalTrigger ) Next( prev time.Time ) time.Time {
+	return prev.Add( time.Duration( d ) )
+}
+
 // Schedule describes how a command should be scheduled
 type Schedule struct {
 	Flags uint32
@@ -69,13 +90,77 @@ type Schedule struct {
 	// timing resolution of the system.
 	MaxWait time.Duration
 
+	// CronSpec, if non-empty, switches the schedule from the
+	// MinWait/MaxWait relative-duration mode to wall-clock calendar
+	// triggering: a five- or six-field cron expression (with an
+	// optional leading seconds field), parsed by sched/cron.go via
+	// ParseCronTrigger. Trigger and NextFire take precedence over
+	// CronSpec if set.
+	CronSpec string
+
+	// NextFire, if non-nil, is called with the current wall-clock time
+	// to obtain the next wall-clock trigger time, switching the
+	// schedule to calendar triggering just like CronSpec. This allows
+	// callers to implement triggering logic that goes beyond what a
+	// cron expression can describe. Trigger takes precedence over
+	// NextFire if both are set.
+	NextFire func( time.Time ) time.Time
+
+	// Trigger, if non-nil, switches the schedule to calendar triggering
+	// just like CronSpec and NextFire, but through the general Trigger
+	// interface instead of a cron expression or a bare function. It
+	// takes precedence over both if more than one is set.
+	Trigger Trigger
+
+	// Retry configures exponential backoff with jitter for consecutive
+	// Command.Execute failures. See the Retry type for details.
+	Retry Retry
+
+	// Descriptor, if a Store is configured via Scheduler.UseStore,
+	// carries whatever information the registered CommandFactory needs
+	// to reconstruct this schedule's Command after a restart. It is
+	// ignored if no Store is configured.
+	Descriptor map[string]interface{}
+
 	// name is used internally to store the name of the schedule
 	name string
 
+	// statusMutex protects attempt, lastErr, lastSuccess and
+	// lastFailure, which are written from the scheduler's single
+	// dispatch goroutine but read from arbitrary goroutines via
+	// Scheduler.Status.
+	statusMutex sync.Mutex
+
+	// attempt counts the number of consecutive Command.Execute
+	// failures. It is reset to zero on success.
+	attempt int
+
+	// lastErr stores the most recent Command.Execute error.
+	lastErr error
+
+	// lastSuccess is the wall-clock time of the most recent successful
+	// Command.Execute, or the zero Time if it has never succeeded.
+	lastSuccess time.Time
+
+	// lastFailure is the wall-clock time of the most recent failed
+	// Command.Execute, or the zero Time if it has never failed.
+	lastFailure time.Time
+
 	// triggerTime is used internally to store the time when
-	// a MinWait or a MaxWait elapses.
+	// a MinWait or a MaxWait elapses, or the monotonic equivalent of
+	// the next cron/NextFire wall-clock target.
 	triggerTime monotonicTime
 
+	// index is the schedule's position in whichever scheduleStack
+	// (waitingQueue or pendingQueue) currently holds it, maintained by
+	// scheduleStack's heap.Interface methods so ScheduleQueue.Remove can
+	// evict it directly instead of waiting for it to reach the front of
+	// the heap. It is -1 while the schedule is not held by any queue.
+	index int
+
+	// cron is used internally to cache the parsed CronSpec.
+	cron *CronTrigger
+
 	// command is used internally to store the actual command.
 	command Command
 
@@ -93,3 +178,71 @@ func ( s *Schedule ) markRemoved() {
 	flags := s.getFlags() | scheduleRemoved
 	atomic.StoreUint32( &s.Flags, flags )
 }
+
+// recordSuccess marks a successful Command.Execute, resetting the
+// consecutive failure count.
+func ( s *Schedule ) recordSuccess() {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	s.attempt = 0
+	s.lastErr = nil
+	s.lastSuccess = time.Now()
+}
+
+// recordFailure marks a failed Command.Execute, incrementing the
+// consecutive failure count and returning its new value.
+func ( s *Schedule ) recordFailure( err error ) ( attempt int ) {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	s.attempt++
+	s.lastErr = err
+	s.lastFailure = time.Now()
+
+	return s.attempt
+}
+
+// isCron reports whether the schedule is driven by a calendar trigger
+// (Trigger, NextFire or CronSpec) rather than by MinWait/MaxWait.
+func ( s *Schedule ) isCron() bool {
+	return s.Trigger != nil || s.NextFire != nil || s.CronSpec != ""
+}
+
+// parseCron parses and caches CronSpec. It is a no-op if Trigger or
+// NextFire is set, or CronSpec has already been parsed.
+func ( s *Schedule ) parseCron() error {
+	if s.Trigger != nil || s.NextFire != nil || s.cron != nil || s.CronSpec == "" {
+		return nil
+	}
+	cron, err := ParseCronTrigger( s.CronSpec )
+	if err != nil {
+		return fmt.Errorf( "invalid cron spec '%s': %v", s.CronSpec, err )
+	}
+	s.cron = cron
+
+	return nil
+}
+
+// nextCronTrigger computes the monotonic trigger time corresponding to
+// the next Trigger/NextFire/cron wall-clock fire time after now. now is
+// used as the monotonic anchor; the wall-clock delay until the next
+// fire time is computed from time.Now() so that clock adjustments
+// between calls (DST, NTP corrections, ...) do not desynchronise the
+// monotonic trigger from the wall-clock target.
+func ( s *Schedule ) nextCronTrigger( now monotonicTime ) monotonicTime {
+	wallNow := time.Now()
+	var wallNext time.Time
+	switch {
+	case s.Trigger != nil:
+		wallNext = s.Trigger.Next( wallNow )
+	case s.NextFire != nil:
+		wallNext = s.NextFire( wallNow )
+	default:
+		wallNext = s.cron.NextAfter( wallNow )
+	}
+	delay := wallNext.Sub( wallNow )
+	if delay < 0 {
+		delay = 0
+	}
+
+	return now.Add( delay )
+}
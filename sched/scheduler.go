@@ -25,6 +25,7 @@ package sched
 import(
 	"errors"
 	"fmt"
+	"github.com/TheCount/goodbus/sched/store"
 	"sync"
 	"time"
 )
@@ -58,12 +59,66 @@ type Scheduler struct {
 	// pendingQueue is the queue of commands waiting to be executed.
 	pendingQueue *ScheduleQueue
 
+	// queueMutex protects waitingQueue and pendingQueue against the
+	// concurrent access Remove needs to evict a schedule immediately,
+	// instead of waiting for it to reach the front of its queue. The
+	// run goroutine, which otherwise owns both queues exclusively, also
+	// holds queueMutex while touching them.
+	queueMutex sync.Mutex
+
 	// idleRing is the ring of commands executed only when the scheduler
 	// is otherwise idle.
 	idleRing ScheduleRing
 
+	// runMutex protects isRunning.
+	runMutex sync.Mutex
+
 	// isRunning indicates whether the scheduler is currently running.
 	isRunning bool
+
+	// store persists schedules across restarts.
+	// It defaults to store.NoopStore{}, so a scheduler which never
+	// calls UseStore pays no persistence overhead.
+	store store.Store
+
+	// commandFactory reconstructs a Command from a persisted
+	// schedule's Descriptor when restoring schedules on Start.
+	// It is nil unless UseStore has been called.
+	commandFactory CommandFactory
+}
+
+var(
+	// ErrAlreadyStarted is returned by Start if the scheduler is
+	// already running.
+	ErrAlreadyStarted = errors.New( "scheduler already running" )
+
+	// ErrAlreadyStopped is returned by SignalStop and WaitStop if the
+	// scheduler is not currently running.
+	ErrAlreadyStopped = errors.New( "scheduler already stopped" )
+
+	// ErrBadBacklog is returned by Start if a negative error backlog is
+	// passed.
+	ErrBadBacklog = errors.New( "bad error backlog" )
+)
+
+// IsRunning atomically reports whether the scheduler is currently
+// running, i.e. whether it was started and has not been fully stopped
+// via WaitStop yet.
+func ( s *Scheduler ) IsRunning() bool {
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
+
+	return s.isRunning
+}
+
+// setRunning atomically sets isRunning and returns its previous value.
+func ( s *Scheduler ) setRunning( running bool ) ( previous bool ) {
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
+	previous = s.isRunning
+	s.isRunning = running
+
+	return
 }
 
 // NewScheduler creates a new scheduler.
@@ -74,24 +129,37 @@ type Scheduler struct {
 func NewScheduler( scheduleBufferSize int ) *Scheduler {
 	return &Scheduler{
 		scheduleChan: make( chan *Schedule, scheduleBufferSize ),
+		store: store.NoopStore{},
 	}
 }
 
 // execute executes a command
 // unless the corresponding schedule is marked as removed.
-// Returns whether the command should be removed.
-// If it should be removed, it is also marked as such and
+// Returns whether the command should be removed, and, if the schedule's
+// Retry policy applies after a failure, the backoff duration to use for
+// the next trigger instead of the regular MinWait/MaxWait or cron
+// cadence (zero if the regular cadence should be used).
+// If the command should be removed, it is also marked as such and
 // already removed from the schedule map.
-func ( s *Scheduler ) execute( candidate *Schedule ) ( shouldRemove bool ) {
+func ( s *Scheduler ) execute( candidate *Schedule ) ( shouldRemove bool, backoff time.Duration ) {
 	shouldRemove = false
 	flags := candidate.getFlags()
 	if flags & scheduleRemoved == 0 {
 		err := candidate.command.Execute()
 		if err != nil {
-			s.errChan <- err
-			if flags & ScheduleRemoveOnError != 0 {
+			attempt := candidate.recordFailure( err )
+			if candidate.Retry.MaxAttempts > 0 && attempt < candidate.Retry.MaxAttempts {
+				backoff = candidate.nextBackoff( attempt )
+			} else if flags & ScheduleRemoveOnError != 0 {
 				shouldRemove = true
 			}
+			s.errChan <- &RetryError{
+				Err: err,
+				Attempt: attempt,
+				NextBackoff: backoff,
+			}
+		} else {
+			candidate.recordSuccess()
 		}
 	} else {
 		shouldRemove = true
@@ -117,34 +185,56 @@ func ( s *Scheduler ) doSomeWork() bool {
 	now := monotonicNow()
 
 	// Move a schedule from the waiting queue to the pending queue if appropriate
+	s.queueMutex.Lock()
 	candidate := s.waitingQueue.Peek()
 	if candidate != nil && candidate.triggerTime <= now {
 		s.waitingQueue.Pop()
 		candidate.triggerTime = candidate.triggerTime.Add( candidate.MaxWait - candidate.MinWait )
 		s.pendingQueue.Push( candidate )
 	}
+	s.queueMutex.Unlock()
 
 	// Execute a command from the pending queue if appropriate
 	var didExecute bool
+	s.queueMutex.Lock()
 	candidate = s.pendingQueue.Peek()
 	if candidate != nil && candidate.triggerTime.Before( now ) {
 		s.pendingQueue.Pop()
-		shouldRemove := s.execute( candidate )
+	} else {
+		candidate = nil
+	}
+	s.queueMutex.Unlock()
+	if candidate != nil {
+		shouldRemove, backoff := s.execute( candidate )
 		didExecute = true
 		if !shouldRemove {
-			candidate.triggerTime = candidate.triggerTime.Add( candidate.MinWait )
-			flags := candidate.getFlags()
-			if candidate.triggerTime < now && ( flags & ScheduleBurst ) == 0 {
-				candidate.triggerTime = now.Add( candidate.MinWait )
+			if backoff > 0 {
+				candidate.triggerTime = now.Add( backoff )
+				s.queueMutex.Lock()
+				s.pendingQueue.Push( candidate )
+				s.queueMutex.Unlock()
+			} else if candidate.isCron() {
+				candidate.triggerTime = candidate.nextCronTrigger( now )
+				s.queueMutex.Lock()
+				s.pendingQueue.Push( candidate )
+				s.queueMutex.Unlock()
+			} else {
+				candidate.triggerTime = candidate.triggerTime.Add( candidate.MinWait )
+				flags := candidate.getFlags()
+				if candidate.triggerTime < now && ( flags & ScheduleBurst ) == 0 {
+					candidate.triggerTime = now.Add( candidate.MinWait )
+				}
+				s.queueMutex.Lock()
+				s.waitingQueue.Push( candidate )
+				s.queueMutex.Unlock()
 			}
-			s.waitingQueue.Push( candidate )
 		}
 	}
 
 	// Execute a command from the idle ring if we haven't done so yet
 	if !( didExecute || s.idleRing.IsEmpty() ) {
 		candidate = s.idleRing.Next()
-		shouldRemove := s.execute( candidate )
+		shouldRemove, _ := s.execute( candidate )
 		didExecute = true
 		if shouldRemove {
 			s.idleRing.Remove()
@@ -154,6 +244,7 @@ func ( s *Scheduler ) doSomeWork() bool {
 	// Get a new schedule
 	chanStillOpen := true
 	later := inTheFuture
+	s.queueMutex.Lock()
 	candidate = s.waitingQueue.Peek()
 	if candidate != nil {
 		later = candidate.triggerTime
@@ -162,6 +253,7 @@ func ( s *Scheduler ) doSomeWork() bool {
 	if candidate != nil && later > candidate.triggerTime {
 		later = candidate.triggerTime
 	}
+	s.queueMutex.Unlock()
 	if didExecute || later.Before( now ) {
 		select {
 		case candidate, chanStillOpen = <-s.scheduleChan:
@@ -183,8 +275,15 @@ func ( s *Scheduler ) doSomeWork() bool {
 	if candidate != nil {
 		flags := candidate.getFlags()
 		if flags & ScheduleIdle == 0 {
-			candidate.triggerTime = now.Add( candidate.MinWait )
-			s.waitingQueue.Push( candidate )
+			s.queueMutex.Lock()
+			if candidate.isCron() {
+				candidate.triggerTime = candidate.nextCronTrigger( now )
+				s.pendingQueue.Push( candidate )
+			} else {
+				candidate.triggerTime = now.Add( candidate.MinWait )
+				s.waitingQueue.Push( candidate )
+			}
+			s.queueMutex.Unlock()
 		} else {
 			s.idleRing.Insert( candidate )
 		}
@@ -208,13 +307,15 @@ func ( s *Scheduler ) run() {
 // A channel reporting scheduler errors is returned.
 // The buffer size of this channel is given by error backlog.
 // On success, the second return value is nil.
-// Otherwise, it is an appropriate error message.
+// Otherwise, it is ErrAlreadyStarted or ErrBadBacklog.
+// Start re-initializes all scheduler state, so it is safe to call
+// Start, Stop, Start, ... repeatedly on the same Scheduler.
 func ( s *Scheduler ) Start( errorBacklog int ) ( <-chan error, error ) {
-	if s.isRunning {
-		return nil, errors.New( "Scheduler already running" )
+	if s.IsRunning() {
+		return nil, ErrAlreadyStarted
 	}
 	if errorBacklog < 0 {
-		return nil, fmt.Errorf( "Bad error backlog: %d", errorBacklog )
+		return nil, fmt.Errorf( "%w: %d", ErrBadBacklog, errorBacklog )
 	}
 	scheduleBufferSize := cap( s.scheduleChan )
 	errChan := make( chan error, errorBacklog )
@@ -227,7 +328,12 @@ func ( s *Scheduler ) Start( errorBacklog int ) ( <-chan error, error ) {
 	s.idleRing = ScheduleRing{}
 	s.waitGroup.Add( 1 )
 	go s.run()
-	s.isRunning = true
+	s.setRunning( true )
+
+	if err := s.restoreSchedules(); err != nil {
+		s.Stop()
+		return nil, err
+	}
 
 	return errChan, nil
 }
@@ -235,34 +341,56 @@ func ( s *Scheduler ) Start( errorBacklog int ) ( <-chan error, error ) {
 // SignalStop signals the scheduler to stop,
 // but does not wait for it to actually stop.
 // Use the WaitStop method for that.
-func ( s *Scheduler ) SignalStop() {
-	if s.isRunning {
-		close( s.scheduleChan )
+// SignalStop returns ErrAlreadyStopped if the scheduler is not running.
+func ( s *Scheduler ) SignalStop() error {
+	if !s.IsRunning() {
+		return ErrAlreadyStopped
 	}
+	close( s.scheduleChan )
+
+	return nil
 }
 
 // WaitStop waits for the scheduler to stop
 // after a call to SignalStop.
 // Without a call to SignalStop,
 // WaitStop will wait forever.
-func ( s *Scheduler ) WaitStop() {
-	if s.isRunning {
-		s.waitGroup.Wait()
-		s.isRunning = false
+// WaitStop returns ErrAlreadyStopped if the scheduler is not running.
+func ( s *Scheduler ) WaitStop() error {
+	if !s.IsRunning() {
+		return ErrAlreadyStopped
 	}
+	s.waitGroup.Wait()
+	s.setRunning( false )
+
+	return nil
 }
 
 // Stop stops the scheduler.
 // It combines SignalStop and WaitStop into one method.
-func ( s *Scheduler ) Stop() {
-	s.SignalStop()
-	s.WaitStop()
+func ( s *Scheduler ) Stop() error {
+	if err := s.SignalStop(); err != nil {
+		return err
+	}
+
+	return s.WaitStop()
 }
 
 // Add adds a schedule to the scheduler.
 // Can only be called on a started scheduler,
 // but is otherwise goroutine-safe.
+// If a Store is configured via UseStore, the schedule is persisted
+// before it becomes visible in-memory, so a crash cannot lose a
+// schedule the caller believes was added.
 func ( s *Scheduler ) Add( name string, command Command, schedule Schedule ) error {
+	return s.add( name, command, schedule, true )
+}
+
+// add adds a schedule to the scheduler, optionally persisting it to the
+// configured Store first. persist is false when restoring schedules
+// that were already loaded from the Store on Start, to avoid writing
+// them right back.
+func ( s *Scheduler ) add( name string, command Command, schedule Schedule, persist bool ) error {
 	s.mapMutex.Lock()
 	defer s.mapMutex.Unlock()
 	oldSchedule, ok := s.scheduleMap[name]
@@ -278,8 +406,17 @@ func ( s *Scheduler ) Add( name string, command Command, schedule Schedule ) err
 	if schedule.MaxWait < schedule.MinWait {
 		return fmt.Errorf( "Maximum wait %d ns smaller than minimum wait %d ns", schedule.MaxWait, schedule.MinWait )
 	}
+	if err := schedule.parseCron(); err != nil {
+		return err
+	}
+	if persist && s.store != nil {
+		if err := s.store.Save( name, toPersisted( name, schedule ) ); err != nil {
+			return fmt.Errorf( "Unable to persist schedule '%s': %v", name, err )
+		}
+	}
 	schedule.name = name
 	schedule.command = command
+	schedule.index = -1
 	s.scheduleMap[name] = &schedule
 	s.scheduleChan <- &schedule
 
@@ -289,14 +426,34 @@ func ( s *Scheduler ) Add( name string, command Command, schedule Schedule ) err
 // Remove removes a schedule from the scheduler.
 // Can only be called on a started scheduler,
 // but is otherwise goroutine-safe.
+// If a Store is configured via UseStore, the schedule is deleted from
+// it as well, so it does not reappear on the next restart.
+// Unlike the idle ring, waitingQueue and pendingQueue support immediate
+// eviction, so Remove drops the schedule from whichever of those two
+// holds it right away, instead of leaving it to be skipped the next
+// time it would have triggered.
 func ( s *Scheduler ) Remove( name string ) error {
 	s.mapMutex.Lock()
 	schedule, ok := s.scheduleMap[name]
+	if ok {
+		delete( s.scheduleMap, name )
+	}
 	s.mapMutex.Unlock()
 	if !ok || ( schedule.getFlags() & scheduleRemoved != 0 ) {
 		return fmt.Errorf( "A schedule named '%s' does not exist", name )
 	}
+	if s.store != nil {
+		if err := s.store.Delete( name ); err != nil {
+			return fmt.Errorf( "Unable to delete persisted schedule '%s': %v", name, err )
+		}
+	}
 	schedule.markRemoved()
 
+	s.queueMutex.Lock()
+	if !s.waitingQueue.Remove( schedule ) {
+		s.pendingQueue.Remove( schedule )
+	}
+	s.queueMutex.Unlock()
+
 	return nil
 }
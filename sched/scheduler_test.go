@@ -23,6 +23,7 @@ SOFTWARE.
 package sched
 
 import(
+	"errors"
 	"testing"
 	"time"
 )
@@ -101,6 +102,48 @@ func TestStartScheduler( t *testing.T ) {
 	}
 }
 
+func TestSchedulerTypedErrors( t *testing.T ) {
+	s := NewScheduler( 5 )
+	if _, err := s.Start( -1 ); !errors.Is( err, ErrBadBacklog ) {
+		t.Errorf( "Expected ErrBadBacklog, got %v", err )
+	}
+	if err := s.SignalStop(); !errors.Is( err, ErrAlreadyStopped ) {
+		t.Errorf( "Expected ErrAlreadyStopped, got %v", err )
+	}
+	if err := s.WaitStop(); !errors.Is( err, ErrAlreadyStopped ) {
+		t.Errorf( "Expected ErrAlreadyStopped, got %v", err )
+	}
+
+	if _, err := s.Start( 5 ); err != nil {
+		t.Fatalf( "Unable to start scheduler: %v", err )
+	}
+	if _, err := s.Start( 5 ); !errors.Is( err, ErrAlreadyStarted ) {
+		t.Errorf( "Expected ErrAlreadyStarted, got %v", err )
+	}
+	s.Stop()
+}
+
+func TestSchedulerRestart( t *testing.T ) {
+	s := NewScheduler( 5 )
+	for i := 0; i < 10; i++ {
+		if s.IsRunning() {
+			t.Fatalf( "Scheduler reports running before Start on iteration %d", i )
+		}
+		if _, err := s.Start( 5 ); err != nil {
+			t.Fatalf( "Unable to start scheduler on iteration %d: %v", i, err )
+		}
+		if !s.IsRunning() {
+			t.Fatalf( "Scheduler does not report running after Start on iteration %d", i )
+		}
+		if err := s.Stop(); err != nil {
+			t.Fatalf( "Unable to stop scheduler on iteration %d: %v", i, err )
+		}
+		if s.IsRunning() {
+			t.Fatalf( "Scheduler still reports running after Stop on iteration %d", i )
+		}
+	}
+}
+
 func TestAddScheduler( t *testing.T ) {
 	s := NewScheduler( 5 )
 	_, err := s.Start( 5 )
@@ -146,3 +189,37 @@ func TestRepeat( t *testing.T ) {
 		t.Error( "Command yielded wrong result" )
 	}
 }
+
+func TestRemoveScheduler( t *testing.T ) {
+	s := NewScheduler( 5 )
+	_, err := s.Start( 5 )
+	if err != nil {
+		t.Error( "Unable to start scheduler" )
+	}
+	reportChan := make( chan int )
+
+	err = s.Add( "test", &TestCommand{ 1, nil, reportChan }, Schedule{ Flags: ScheduleRepeat, MinWait: time.Hour, MaxWait: time.Hour } )
+	if err != nil {
+		t.Error( "Unable to add command" )
+	}
+	// Give the scheduler's run goroutine a chance to move the new
+	// schedule from scheduleChan into waitingQueue, so Remove exercises
+	// immediate heap eviction rather than racing the insertion.
+	time.Sleep( 10 * time.Millisecond )
+
+	if err := s.Remove( "test" ); err != nil {
+		t.Error( "Unable to remove command" )
+	}
+	if err := s.Remove( "test" ); err == nil {
+		t.Error( "Successfully removed command twice" )
+	}
+	if _, ok := s.Status( "test" ); ok {
+		t.Error( "Removed command still reported as scheduled" )
+	}
+
+	select {
+	case <-reportChan:
+		t.Error( "Removed command still executed" )
+	case <-time.After( 100 * time.Millisecond ):
+	}
+}
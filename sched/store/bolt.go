@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package store
+
+import(
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+)
+
+// boltBucket is the name of the bbolt bucket holding persisted
+// schedules, keyed by schedule name, JSON-encoded PersistedSchedule
+// values.
+var boltBucket = []byte( "schedules" )
+
+// BoltStore is a Store backed by a single embedded bbolt database file,
+// so schedules survive a process restart without an external
+// dependency.
+type BoltStore struct {
+	db *bbolt.DB
+}
+
+// NewBoltStore opens (creating if necessary) a bbolt database at path
+// and returns a BoltStore backed by it.
+func NewBoltStore( path string ) ( *BoltStore, error ) {
+	db, err := bbolt.Open( path, 0600, nil )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to open bolt database '%s': %v", path, err )
+	}
+	err = db.Update( func( tx *bbolt.Tx ) error {
+		_, err := tx.CreateBucketIfNotExists( boltBucket )
+
+		return err
+	} )
+	if err != nil {
+		db.Close()
+
+		return nil, fmt.Errorf( "Unable to create bucket in bolt database '%s': %v", path, err )
+	}
+
+	return &BoltStore{ db: db }, nil
+}
+
+// Save implements Store.
+func ( bs *BoltStore ) Save( name string, s PersistedSchedule ) error {
+	data, err := json.Marshal( s )
+	if err != nil {
+		return fmt.Errorf( "Unable to marshal persisted schedule '%s': %v", name, err )
+	}
+
+	return bs.db.Update( func( tx *bbolt.Tx ) error {
+		return tx.Bucket( boltBucket ).Put( []byte( name ), data )
+	} )
+}
+
+// Delete implements Store.
+func ( bs *BoltStore ) Delete( name string ) error {
+	return bs.db.Update( func( tx *bbolt.Tx ) error {
+		return tx.Bucket( boltBucket ).Delete( []byte( name ) )
+	} )
+}
+
+// LoadAll implements Store.
+func ( bs *BoltStore ) LoadAll() ( []PersistedSchedule, error ) {
+	var result []PersistedSchedule
+	err := bs.db.View( func( tx *bbolt.Tx ) error {
+		return tx.Bucket( boltBucket ).ForEach( func( key, data []byte ) error {
+			var p PersistedSchedule
+			if err := json.Unmarshal( data, &p ); err != nil {
+				return fmt.Errorf( "Unable to unmarshal persisted schedule '%s': %v", key, err )
+			}
+			result = append( result, p )
+
+			return nil
+		} )
+	} )
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Close closes the underlying bbolt database.
+func ( bs *BoltStore ) Close() error {
+	return bs.db.Close()
+}
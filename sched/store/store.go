@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package store provides pluggable persistence for sched.Scheduler, so a
+// process can resume polling after a restart without the caller having
+// to re-Add every schedule by hand.
+package store
+
+import(
+	"time"
+)
+
+// PersistedSchedule is the serializable subset of a sched.Schedule,
+// together with a Descriptor a sched.CommandFactory can use to
+// reconstruct its Command.
+type PersistedSchedule struct {
+	// Name is the schedule's name, as passed to sched.Scheduler.Add.
+	Name string
+
+	// Flags holds the sched.Schedule.Flags bits.
+	Flags uint32
+
+	// MinWait is the schedule's MinWait.
+	MinWait time.Duration
+
+	// MaxWait is the schedule's MaxWait.
+	MaxWait time.Duration
+
+	// CronSpec is the schedule's CronSpec, if any.
+	CronSpec string
+
+	// Descriptor carries whatever information a registered
+	// CommandFactory needs to reconstruct the Command for this
+	// schedule, e.g. a command type key and its parameters.
+	Descriptor map[string]interface{}
+}
+
+// Store persists schedules so a sched.Scheduler can reconstruct them on
+// the next Start via a registered CommandFactory, instead of losing
+// every schedule across a process restart.
+type Store interface {
+	// Save persists (or overwrites) the schedule named name.
+	Save( name string, s PersistedSchedule ) error
+
+	// Delete removes the persisted schedule named name, if any.
+	Delete( name string ) error
+
+	// LoadAll returns all currently persisted schedules.
+	LoadAll() ( []PersistedSchedule, error )
+}
+
+// NoopStore is a Store that persists nothing. It is the default Store
+// for a sched.Scheduler that has not called UseStore, so schedulers
+// without persistence requirements pay no overhead.
+type NoopStore struct{}
+
+// Save implements Store by discarding s.
+func ( NoopStore ) Save( name string, s PersistedSchedule ) error {
+	return nil
+}
+
+// Delete implements Store as a no-op.
+func ( NoopStore ) Delete( name string ) error {
+	return nil
+}
+
+// LoadAll implements Store by always reporting no persisted schedules.
+func ( NoopStore ) LoadAll() ( []PersistedSchedule, error ) {
+	return nil, nil
+}
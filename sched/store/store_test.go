@@ -0,0 +1,45 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package store
+
+import(
+	"testing"
+)
+
+func TestNoopStore( t *testing.T ) {
+	var s Store = NoopStore{}
+
+	if err := s.Save( "test", PersistedSchedule{ Name: "test" } ); err != nil {
+		t.Errorf( "Unexpected error from Save: %v", err )
+	}
+	if err := s.Delete( "test" ); err != nil {
+		t.Errorf( "Unexpected error from Delete: %v", err )
+	}
+	all, err := s.LoadAll()
+	if err != nil {
+		t.Errorf( "Unexpected error from LoadAll: %v", err )
+	}
+	if len( all ) != 0 {
+		t.Errorf( "Expected no persisted schedules, got %d", len( all ) )
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package sink
+
+import(
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+)
+
+// diskBufferBucket holds rows pending a retry against the database,
+// keyed by an auto-incrementing bbolt sequence number so buffered rows
+// replay in the order they were written.
+var diskBufferBucket = []byte( "pending" )
+
+// DiskBuffer persists rows a PostgresSink could not write immediately,
+// so a temporary database outage does not lose samples. Buffered rows
+// are replayed, oldest first, the next time the database accepts
+// writes.
+type DiskBuffer struct {
+	db *bbolt.DB
+}
+
+// NewDiskBuffer opens (creating if necessary) a bbolt database at path
+// and returns a DiskBuffer backed by it.
+func NewDiskBuffer( path string ) ( *DiskBuffer, error ) {
+	db, err := bbolt.Open( path, 0600, nil )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to open disk buffer '%s': %v", path, err )
+	}
+	err = db.Update( func( tx *bbolt.Tx ) error {
+		_, err := tx.CreateBucketIfNotExists( diskBufferBucket )
+
+		return err
+	} )
+	if err != nil {
+		db.Close()
+
+		return nil, fmt.Errorf( "Unable to create bucket in disk buffer '%s': %v", path, err )
+	}
+
+	return &DiskBuffer{ db: db }, nil
+}
+
+// Replace atomically discards whatever rows are currently buffered and
+// stores rows in their place. Callers pass the full set of rows still
+// needing a write, including anything Load returned earlier, so a
+// failed flush does not buffer the same rows twice.
+func ( db *DiskBuffer ) Replace( rows []Row ) error {
+	return db.db.Update( func( tx *bbolt.Tx ) error {
+		if err := tx.DeleteBucket( diskBufferBucket ); err != nil {
+			return fmt.Errorf( "Unable to clear disk buffer: %v", err )
+		}
+		bucket, err := tx.CreateBucket( diskBufferBucket )
+		if err != nil {
+			return fmt.Errorf( "Unable to recreate disk buffer bucket: %v", err )
+		}
+		for _, row := range rows {
+			data, err := json.Marshal( row )
+			if err != nil {
+				return fmt.Errorf( "Unable to marshal buffered row: %v", err )
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return fmt.Errorf( "Unable to obtain disk buffer sequence: %v", err )
+			}
+			if err := bucket.Put( encodeSeq( seq ), data ); err != nil {
+				return fmt.Errorf( "Unable to store buffered row: %v", err )
+			}
+		}
+
+		return nil
+	} )
+}
+
+// Load returns all currently buffered rows, oldest first.
+func ( db *DiskBuffer ) Load() ( []Row, error ) {
+	var result []Row
+	err := db.db.View( func( tx *bbolt.Tx ) error {
+		return tx.Bucket( diskBufferBucket ).ForEach( func( key, data []byte ) error {
+			var row Row
+			if err := json.Unmarshal( data, &row ); err != nil {
+				return fmt.Errorf( "Unable to unmarshal buffered row: %v", err )
+			}
+			result = append( result, row )
+
+			return nil
+		} )
+	} )
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Clear discards all buffered rows, once they have been successfully
+// written to the database.
+func ( db *DiskBuffer ) Clear() error {
+	return db.db.Update( func( tx *bbolt.Tx ) error {
+		if err := tx.DeleteBucket( diskBufferBucket ); err != nil {
+			return fmt.Errorf( "Unable to clear disk buffer: %v", err )
+		}
+		_, err := tx.CreateBucket( diskBufferBucket )
+
+		return err
+	} )
+}
+
+// Close closes the underlying bbolt database.
+func ( db *DiskBuffer ) Close() error {
+	return db.db.Close()
+}
+
+// encodeSeq encodes a bbolt auto-increment sequence number as a
+// big-endian key, so buffered rows sort, and therefore replay, in
+// insertion order.
+func encodeSeq( seq uint64 ) []byte {
+	key := make( []byte, 8 )
+	binary.BigEndian.PutUint64( key, seq )
+
+	return key
+}
@@ -0,0 +1,251 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package sink
+
+import(
+	"context"
+	"fmt"
+	"github.com/TheCount/goodbus/builder"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log"
+	"sync"
+	"time"
+)
+
+// dDefaultFlushInterval is how often a PostgresSink flushes pending
+// rows if flushRows has not already forced an earlier flush.
+const dDefaultFlushInterval = 5 * time.Second
+
+// dDefaultFlushRows is how many pending rows trigger an immediate
+// flush, independent of dDefaultFlushInterval.
+const dDefaultFlushRows = 100
+
+// createTableSQL creates the hypertable-friendly schema a PostgresSink
+// writes into, if it does not already exist. Promoting the table to a
+// TimescaleDB hypertable (via create_hypertable) is left to the
+// operator, so a PostgresSink works against plain Postgres too.
+const createTableSQL = `CREATE TABLE IF NOT EXISTS goodbus_samples (
+	ts timestamptz NOT NULL,
+	source text NOT NULL,
+	name text NOT NULL,
+	value double precision,
+	bits jsonb
+)`
+
+// insertSQL inserts a single Row into the schema created by
+// createTableSQL.
+const insertSQL = `INSERT INTO goodbus_samples ( ts, source, name, value, bits ) VALUES ( $1, $2, $3, $4, $5 )`
+
+// PostgresSink batches the value objects written through the Sinks
+// returned by ForSource into a Postgres/TimescaleDB table, flushing on
+// whichever comes first of flushRows pending rows or flushInterval
+// elapsing. If a flush fails, its rows are handed to buffer instead of
+// being lost, and retried on every subsequent flush, so a temporary
+// database outage does not lose samples.
+type PostgresSink struct {
+	pool *pgxpool.Pool
+	buffer *DiskBuffer
+
+	flushRows int
+	flushInterval time.Duration
+
+	mutex sync.Mutex
+	pending []Row
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewPostgresSink opens a pgxpool.Pool against dsn and ensures the
+// sample table exists. flushInterval and flushRows default to
+// dDefaultFlushInterval and dDefaultFlushRows if zero. bufferPath, if
+// non-empty, is a bbolt database file used to buffer rows across a
+// database outage; if empty, a failed flush's rows are simply lost.
+func NewPostgresSink( dsn string, flushInterval time.Duration, flushRows int, bufferPath string ) ( *PostgresSink, error ) {
+	pool, err := pgxpool.New( context.Background(), dsn )
+	if err != nil {
+		return nil, fmt.Errorf( "Unable to open postgres connection pool: %v", err )
+	}
+	if _, err := pool.Exec( context.Background(), createTableSQL ); err != nil {
+		pool.Close()
+
+		return nil, fmt.Errorf( "Unable to create sample table: %v", err )
+	}
+
+	var buffer *DiskBuffer
+	if bufferPath != "" {
+		buffer, err = NewDiskBuffer( bufferPath )
+		if err != nil {
+			pool.Close()
+
+			return nil, fmt.Errorf( "Unable to open disk buffer '%s': %v", bufferPath, err )
+		}
+	}
+	if flushInterval <= 0 {
+		flushInterval = dDefaultFlushInterval
+	}
+	if flushRows <= 0 {
+		flushRows = dDefaultFlushRows
+	}
+
+	ps := &PostgresSink{
+		pool: pool,
+		buffer: buffer,
+		flushRows: flushRows,
+		flushInterval: flushInterval,
+		stop: make( chan struct{} ),
+		done: make( chan struct{} ),
+	}
+	go ps.flushLoop()
+
+	return ps, nil
+}
+
+// ForSource returns a Sink which tags every written object's rows with
+// source, normally the polling command's name, before handing them to
+// this PostgresSink's shared batching and flushing.
+func ( ps *PostgresSink ) ForSource( source string ) Sink {
+	return &postgresSourceSink{ ps: ps, source: source }
+}
+
+// postgresSourceSink is the Sink ForSource binds to one source name.
+type postgresSourceSink struct {
+	ps *PostgresSink
+	source string
+}
+
+// Write implements Sink.
+func ( s *postgresSourceSink ) Write( obj builder.Object ) error {
+	rows, err := rowsFromObject( s.source, obj )
+	if err != nil {
+		return fmt.Errorf( "Unable to extract rows for source '%s': %v", s.source, err )
+	}
+
+	return s.ps.enqueue( rows )
+}
+
+// enqueue appends rows to the pending batch, flushing immediately once
+// flushRows is reached.
+func ( ps *PostgresSink ) enqueue( rows []Row ) error {
+	ps.mutex.Lock()
+	ps.pending = append( ps.pending, rows... )
+	full := len( ps.pending ) >= ps.flushRows
+	ps.mutex.Unlock()
+	if !full {
+		return nil
+	}
+
+	return ps.flush()
+}
+
+// flush writes all pending rows, plus anything still held in the disk
+// buffer from a previous outage, in a single batch. If the write
+// fails, the rows are handed to the disk buffer instead of being lost,
+// for the next flush to retry.
+func ( ps *PostgresSink ) flush() error {
+	ps.mutex.Lock()
+	rows := ps.pending
+	ps.pending = nil
+	ps.mutex.Unlock()
+
+	var buffered []Row
+	if ps.buffer != nil {
+		var err error
+		buffered, err = ps.buffer.Load()
+		if err != nil {
+			return fmt.Errorf( "Unable to load disk-buffered rows: %v", err )
+		}
+	}
+	all := append( buffered, rows... )
+	if len( all ) == 0 {
+		return nil
+	}
+
+	if err := ps.writeRows( all ); err != nil {
+		if ps.buffer == nil {
+			return fmt.Errorf( "Unable to write rows: %v", err )
+		}
+		if bufErr := ps.buffer.Replace( all ); bufErr != nil {
+			return fmt.Errorf( "Unable to write rows (%v) and unable to buffer them to disk: %v", err, bufErr )
+		}
+
+		return nil
+	}
+	if ps.buffer != nil {
+		return ps.buffer.Clear()
+	}
+
+	return nil
+}
+
+// writeRows writes rows to postgres in a single pgx.Batch.
+func ( ps *PostgresSink ) writeRows( rows []Row ) error {
+	batch := &pgx.Batch{}
+	for _, row := range rows {
+		batch.Queue( insertSQL, row.TS, row.Source, row.Name, row.Value, row.Bits )
+	}
+	br := ps.pool.SendBatch( context.Background(), batch )
+	defer br.Close()
+	for range rows {
+		if _, err := br.Exec(); err != nil {
+			return fmt.Errorf( "Unable to execute batched insert: %v", err )
+		}
+	}
+
+	return nil
+}
+
+// flushLoop periodically flushes pending rows until Close stops it.
+func ( ps *PostgresSink ) flushLoop() {
+	ticker := time.NewTicker( ps.flushInterval )
+	defer ticker.Stop()
+	defer close( ps.done )
+	for {
+		select {
+		case <- ticker.C:
+			if err := ps.flush(); err != nil {
+				log.Printf( "sink: %v", err )
+			}
+		case <- ps.stop:
+			if err := ps.flush(); err != nil {
+				log.Printf( "sink: %v", err )
+			}
+
+			return
+		}
+	}
+}
+
+// Close stops the flush loop, flushes one last time, and releases the
+// database pool and disk buffer.
+func ( ps *PostgresSink ) Close() error {
+	close( ps.stop )
+	<- ps.done
+	ps.pool.Close()
+	if ps.buffer == nil {
+		return nil
+	}
+
+	return ps.buffer.Close()
+}
@@ -0,0 +1,140 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package sink
+
+import(
+	"encoding/json"
+	"fmt"
+	"github.com/TheCount/goodbus/builder"
+	"math"
+	"time"
+)
+
+// These keys mirror the shape httpd's buildObject/buildValue produce:
+// a builder.Dict with a "time" float and a "values" dictionary of
+// named value dictionaries, each tagged with a "type" of "number",
+// "bitfield" or "string" and holding its payload under "value".
+const(
+	kBitfield = "bitfield"
+	kNumber = "number"
+	kString = "string"
+	kTime = "time"
+	kType = "type"
+	kValue = "value"
+	kValues = "values"
+)
+
+// Row is one sample ready to be inserted into a (ts, source, name,
+// value, bits) table: a numeric value has Value set and Bits nil, a
+// bitfield value has Bits set (its bit names and states, JSON-encoded)
+// and Value nil.
+type Row struct {
+	TS time.Time
+	Source string
+	Name string
+	Value *float64
+	Bits json.RawMessage
+}
+
+// rowsFromObject converts one buildObject result into the rows to be
+// written for source, normally the polling command's name. String
+// values have no natural place in the (value, bits) schema and are
+// silently skipped, the same way buildObject itself has no opinion on
+// how a consumer other than JSON encoding should treat them.
+func rowsFromObject( source string, obj builder.Object ) ( []Row, error ) {
+	top, ok := obj.( builder.Dict )
+	if !ok {
+		return nil, fmt.Errorf( "Object is not a dictionary: %v", obj )
+	}
+	ts, err := extractTime( top )
+	if err != nil {
+		return nil, err
+	}
+	values, ok := top[kValues].( builder.Dict )
+	if !ok {
+		return nil, fmt.Errorf( "Object has no '%s' dictionary", kValues )
+	}
+
+	rows := make( []Row, 0, len( values ) )
+	for name, value := range values {
+		valueDict, ok := value.( builder.Dict )
+		if !ok {
+			return nil, fmt.Errorf( "Value '%s' is not a dictionary", name )
+		}
+		typ, ok := valueDict[kType].( string )
+		if !ok {
+			return nil, fmt.Errorf( "Value '%s' has no '%s' string", name, kType )
+		}
+		switch typ {
+		case kNumber:
+			num, err := extractNumeric( valueDict[kValue] )
+			if err != nil {
+				return nil, fmt.Errorf( "Value '%s': %v", name, err )
+			}
+			rows = append( rows, Row{ TS: ts, Source: source, Name: name, Value: &num } )
+		case kBitfield:
+			bits, ok := valueDict[kValue].( builder.Dict )
+			if !ok {
+				return nil, fmt.Errorf( "Bitfield value '%s' has no '%s' dictionary", name, kValue )
+			}
+			data, err := json.Marshal( bits )
+			if err != nil {
+				return nil, fmt.Errorf( "Unable to encode bitfield value '%s': %v", name, err )
+			}
+			rows = append( rows, Row{ TS: ts, Source: source, Name: name, Bits: data } )
+		case kString:
+			// No numeric or jsonb representation; not written.
+		default:
+			return nil, fmt.Errorf( "Value '%s' has unknown type '%s'", name, typ )
+		}
+	}
+
+	return rows, nil
+}
+
+// extractTime recovers the sample time buildObject encodes as
+// seconds-since-epoch plus a fractional-second remainder.
+func extractTime( top builder.Dict ) ( time.Time, error ) {
+	f, ok := top[kTime].( builder.Float )
+	if !ok {
+		return time.Time{}, fmt.Errorf( "Object has no '%s' float", kTime )
+	}
+	sec, frac := math.Modf( float64( f ) )
+
+	return time.Unix( int64( sec ), int64( frac * 1e9 ) ), nil
+}
+
+// extractNumeric converts whichever builder numeric type buildValue
+// produced for a "number"-typed value into a plain float64.
+func extractNumeric( value builder.Object ) ( float64, error ) {
+	switch v := value.( type ) {
+	case builder.Float:
+		return float64( v ), nil
+	case builder.Int:
+		return float64( v ), nil
+	case builder.UInt:
+		return float64( v ), nil
+	default:
+		return 0, fmt.Errorf( "Value is not numeric: %v", value )
+	}
+}
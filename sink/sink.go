@@ -0,0 +1,40 @@
+/*
+Copyright (c) 2017 Alexander Klauer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package sink records the value objects httpd builds from polled
+// modbus data (see buildObject in the httpd package) into durable
+// storage outside of the push-on-demand JSON view, for installations
+// that want to graph or aggregate register data over time.
+package sink
+
+import "github.com/TheCount/goodbus/builder"
+
+// Sink receives one built value object per poll of some command. A
+// concrete sink such as PostgresSink is shared across commands; its
+// ForSource method binds a Sink to the command a value object came
+// from, so the underlying storage can tag rows by source.
+type Sink interface {
+	// Write stores obj, a builder.Dict shaped like buildObject's
+	// result: a "time" float and a "values" dictionary of named,
+	// typed values.
+	Write( obj builder.Object ) error
+}